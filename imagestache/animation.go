@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/unixpickle/mustachemash/mustacher"
+)
+
+const maxPaletteSize = 256
+
+// decodeAnimation decodes an animated GIF.
+// If the data is not a GIF, or if it only has one frame,
+// nil is returned.
+func decodeAnimation(data []byte) (*gif.GIF, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "gif" {
+		return nil, nil
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(anim.Image) < 2 {
+		return nil, nil
+	}
+	return anim, nil
+}
+
+// mustacheAnimation draws mustaches on every frame of an
+// animated GIF.
+//
+// Faces are detected in every keyframe'th frame (and the
+// last frame), and the matches are interpolated for the
+// frames in between.
+//
+// The resulting frames cover the entire canvas, but the
+// original delays and disposal methods are kept.
+// Each frame gets a palette built from the colors of the
+// whole canvas, so local palettes from earlier frames and
+// transparency are preserved.
+func mustacheAnimation(d *mustacher.Detector, anim *gif.GIF, opts *mustacher.DrawOptions,
+	keyframe int) *gif.GIF {
+	frames := animationFrames(anim)
+
+	frameMatches := make([][]*mustacher.Match, len(frames))
+	var keyIndices []int
+	for i := 0; i < len(frames); i += keyframe {
+		keyIndices = append(keyIndices, i)
+	}
+	if last := len(frames) - 1; keyIndices[len(keyIndices)-1] != last {
+		keyIndices = append(keyIndices, last)
+	}
+	for _, i := range keyIndices {
+		frameMatches[i] = d.Match(frames[i])
+		log.Printf("Found %d matches in frame %d.", len(frameMatches[i]), i)
+	}
+	for k := 1; k < len(keyIndices); k++ {
+		start, end := keyIndices[k-1], keyIndices[k]
+		for i := start + 1; i < end; i++ {
+			t := float64(i-start) / float64(end-start)
+			frameMatches[i] = interpolateMatches(frameMatches[start], frameMatches[end], t)
+		}
+	}
+
+	res := &gif.GIF{
+		Delay:           anim.Delay,
+		Disposal:        anim.Disposal,
+		LoopCount:       anim.LoopCount,
+		Config:          anim.Config,
+		BackgroundIndex: anim.BackgroundIndex,
+	}
+	for i, frame := range frames {
+		drawn := mustacher.DrawWithOptions(frame, frameMatches[i], opts)
+		palette, usage := framePalette(frame)
+		palette = mustachePalette(palette, usage, mustacheColors(frameMatches[i], opts))
+		paletted := image.NewPaletted(frame.Bounds(), palette)
+		draw.FloydSteinberg.Draw(paletted, frame.Bounds(), drawn, frame.Bounds().Min)
+		res.Image = append(res.Image, paletted)
+	}
+	return res
+}
+
+// animationFrames renders every frame of an animated GIF
+// onto a full canvas, applying disposal methods.
+func animationFrames(anim *gif.GIF) []*image.RGBA {
+	bounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	if bounds.Empty() {
+		for _, frame := range anim.Image {
+			bounds = bounds.Union(frame.Bounds())
+		}
+	}
+
+	canvas := image.NewRGBA(bounds)
+	var res []*image.RGBA
+	for i, frame := range anim.Image {
+		var disposal byte
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = copyRGBA(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		res = append(res, copyRGBA(canvas))
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.ZP, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return res
+}
+
+func copyRGBA(img *image.RGBA) *image.RGBA {
+	res := image.NewRGBA(img.Bounds())
+	copy(res.Pix, img.Pix)
+	return res
+}
+
+// interpolateMatches blends the matches from two
+// keyframes.
+//
+// Each match is paired with the closest match in the
+// other keyframe, if there is one nearby.
+// Unpaired matches are kept until the halfway point.
+func interpolateMatches(m1, m2 []*mustacher.Match, t float64) []*mustacher.Match {
+	var res []*mustacher.Match
+	used := make([]bool, len(m2))
+	for _, a := range m1 {
+		bestIdx := -1
+		bestDist := a.Radius
+		for j, b := range m2 {
+			dist := math.Hypot(a.X-b.X, a.Y-b.Y)
+			if !used[j] && dist < bestDist {
+				bestIdx = j
+				bestDist = dist
+			}
+		}
+		if bestIdx < 0 {
+			if t < 0.5 {
+				res = append(res, a)
+			}
+			continue
+		}
+		used[bestIdx] = true
+		b := m2[bestIdx]
+		blended := *a
+		blended.X += (b.X - a.X) * t
+		blended.Y += (b.Y - a.Y) * t
+		blended.Radius += (b.Radius - a.Radius) * t
+		blended.Angle += (b.Angle - a.Angle) * t
+		res = append(res, &blended)
+	}
+	if t >= 0.5 {
+		for j, b := range m2 {
+			if !used[j] {
+				res = append(res, b)
+			}
+		}
+	}
+	return res
+}
+
+// mustacheColors lists the solid colors used to draw a
+// set of matches.
+func mustacheColors(matches []*mustacher.Match, opts *mustacher.DrawOptions) []color.Color {
+	res := []color.Color{color.Black}
+	if opts.Color != nil {
+		res = append(res, opts.Color)
+	} else {
+		for _, m := range matches {
+			if m.Color != nil {
+				res = append(res, m.Color)
+			}
+		}
+	}
+	if opts.StrokeWidth > 0 && opts.StrokeColor != nil {
+		res = append(res, opts.StrokeColor)
+	}
+	return res
+}
+
+// framePalette builds a palette from the colors of a
+// composited frame, which may come from several GIF
+// frames with different local palettes.
+// It also returns the number of pixels of each color.
+//
+// If there are too many colors, the most used ones are
+// kept.
+func framePalette(frame *image.RGBA) (color.Palette, []int) {
+	counts := map[color.RGBA]int{}
+	for i := 0; i < len(frame.Pix); i += 4 {
+		c := color.RGBA{R: frame.Pix[i], G: frame.Pix[i+1], B: frame.Pix[i+2], A: frame.Pix[i+3]}
+		counts[c]++
+	}
+	colors := make([]color.RGBA, 0, len(counts))
+	for c := range counts {
+		colors = append(colors, c)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		c1, c2 := colors[i], colors[j]
+		if counts[c1] != counts[c2] {
+			return counts[c1] > counts[c2]
+		}
+		return uint32(c1.R)<<24|uint32(c1.G)<<16|uint32(c1.B)<<8|uint32(c1.A) <
+			uint32(c2.R)<<24|uint32(c2.G)<<16|uint32(c2.B)<<8|uint32(c2.A)
+	})
+	if len(colors) > maxPaletteSize {
+		colors = colors[:maxPaletteSize]
+	}
+	palette := make(color.Palette, len(colors))
+	usage := make([]int, len(colors))
+	for i, c := range colors {
+		palette[i] = c
+		usage[i] = counts[c]
+	}
+	return palette, usage
+}
+
+// mustachePalette extends a palette with the colors of
+// the mustaches.
+// If the palette is full, the least used opaque colors
+// are replaced, according to usage.
+func mustachePalette(palette color.Palette, usage []int, colors []color.Color) color.Palette {
+	res := make(color.Palette, len(palette))
+	copy(res, palette)
+	replaced := make([]bool, maxPaletteSize)
+
+	for _, c := range colors {
+		if paletteHas(res, c) {
+			continue
+		}
+		if len(res) < maxPaletteSize {
+			replaced[len(res)] = true
+			res = append(res, c)
+			continue
+		}
+		leastUsed := -1
+		for i, p := range res {
+			if _, _, _, a := p.RGBA(); a == 0 || replaced[i] {
+				continue
+			}
+			if leastUsed < 0 || usage[i] < usage[leastUsed] {
+				leastUsed = i
+			}
+		}
+		if leastUsed >= 0 {
+			res[leastUsed] = c
+			replaced[leastUsed] = true
+		}
+	}
+	return res
+}
+
+func paletteHas(p color.Palette, c color.Color) bool {
+	r1, g1, b1, a1 := c.RGBA()
+	for _, x := range p {
+		r2, g2, b2, a2 := x.RGBA()
+		if r1>>8 == r2>>8 && g1>>8 == g2>>8 && b1>>8 == b2>>8 && a1>>8 == a2>>8 {
+			return true
+		}
+	}
+	return false
+}
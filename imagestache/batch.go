@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/unixpickle/mustachemash/mustacher"
+)
+
+var batchExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// batchName is passed to the output name template.
+type batchName struct {
+	// Name is the input file's name without its extension.
+	Name string
+
+	// Ext is the input file's extension, including the
+	// leading period.
+	Ext string
+
+	// Index is the index of the input file among all of
+	// the inputs.
+	Index int
+}
+
+type batchJob struct {
+	InPath  string
+	OutPath string
+}
+
+// runBatch mustaches every input image and saves the
+// results in an output directory.
+//
+// Failures are reported as they happen and again at the
+// end, and the result is false if any image failed.
+func runBatch(d *mustacher.Detector, f *Flags, opts *mustacher.DrawOptions, inputs []string,
+	outNames *template.Template) bool {
+	outDir := f.OutDir
+	inPaths, err := expandInputs(inputs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list inputs:", err)
+		return false
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create output directory:", err)
+		return false
+	}
+
+	jobs := make([]batchJob, len(inPaths))
+	outInputs := map[string]string{}
+	for i, inPath := range inPaths {
+		base := filepath.Base(inPath)
+		ext := filepath.Ext(base)
+		var name bytes.Buffer
+		err := outNames.Execute(&name, batchName{
+			Name:  strings.TrimSuffix(base, ext),
+			Ext:   ext,
+			Index: i,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to generate output name:", err)
+			return false
+		}
+		outPath := filepath.Join(outDir, name.String())
+		if other, ok := outInputs[outPath]; ok {
+			fmt.Fprintf(os.Stderr, "Inputs %s and %s would both be saved to %s; "+
+				"use {{.Index}} in the -name template to tell them apart.\n", other, inPath, outPath)
+			return false
+		}
+		outInputs[outPath] = inPath
+		jobs[i] = batchJob{InPath: inPath, OutPath: outPath}
+	}
+
+	jobChan := make(chan batchJob)
+	go func() {
+		for _, job := range jobs {
+			jobChan <- job
+		}
+		close(jobChan)
+	}()
+
+	var failLock sync.Mutex
+	var failures []string
+	var wg sync.WaitGroup
+	for i := 0; i < f.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobChan {
+				log.Println("Processing", job.InPath)
+				if err := processImage(d, f, opts, job.InPath, job.OutPath); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to process %s: %s\n", job.InPath, err)
+					failLock.Lock()
+					failures = append(failures, job.InPath)
+					failLock.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d images failed:\n", len(failures), len(jobs))
+		for _, failure := range failures {
+			fmt.Fprintln(os.Stderr, " ", failure)
+		}
+		return false
+	}
+	return true
+}
+
+// defaultExtension returns the file extension for the
+// output format, which is used in the default output name
+// template.
+func (f *Flags) defaultExtension() string {
+	if f.JSON {
+		return ".json"
+	}
+	switch format, _ := outputFormat(f.Format, StdioPath); format {
+	case "jpeg":
+		return ".jpg"
+	case "gif":
+		return ".gif"
+	}
+	return ".png"
+}
+
+// expandInputs turns a list of files, directories, and
+// glob patterns into a list of files.
+// Directories are searched (non-recursively) for files
+// with image extensions.
+func expandInputs(inputs []string) ([]string, error) {
+	var res []string
+	for _, input := range inputs {
+		if info, err := os.Stat(input); err == nil {
+			if !info.IsDir() {
+				res = append(res, input)
+				continue
+			}
+			listing, err := ioutil.ReadDir(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range listing {
+				if !item.IsDir() && hasImageExtension(item.Name()) {
+					res = append(res, filepath.Join(input, item.Name()))
+				}
+			}
+			continue
+		}
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, err
+		} else if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", input)
+		}
+		res = append(res, matches...)
+	}
+	return res, nil
+}
+
+func hasImageExtension(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, x := range batchExtensions {
+		if x == ext {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/unixpickle/mustachemash/mustacher"
+)
+
+func readInput(path string) ([]byte, error) {
+	if path == StdioPath {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+func writeImage(path string, img image.Image, f *Flags) error {
+	format, err := outputFormat(f.Format, path)
+	if err != nil {
+		return err
+	}
+	w, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: f.Quality})
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return png.Encode(w, img)
+	}
+}
+
+func writeAnimation(path string, g *gif.GIF) error {
+	w, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return gif.EncodeAll(w, g)
+}
+
+func writeMatches(path string, matches []*mustacher.Match) error {
+	if matches == nil {
+		matches = []*mustacher.Match{}
+	}
+	data, err := json.MarshalIndent(matches, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == StdioPath {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (n nopCloser) Close() error {
+	return nil
+}
+
+// outputFormat determines the format of an output file,
+// either from an explicit format name or from the
+// extension of the output path.
+func outputFormat(format, path string) (string, error) {
+	if format == "" {
+		if path == StdioPath || path == "" {
+			return "png", nil
+		}
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if format == "" {
+			return "", fmt.Errorf("cannot infer format of %s; use -format", path)
+		}
+	}
+	switch strings.ToLower(format) {
+	case "png":
+		return "png", nil
+	case "jpg", "jpeg":
+		return "jpeg", nil
+	case "gif":
+		return "gif", nil
+	case "webp":
+		return "", errors.New("WebP output is not supported; WebP is only supported for input")
+	}
+	return "", errors.New("unknown output format: " + format)
+}
@@ -2,53 +2,262 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"image"
-	_ "image/jpeg"
-	"image/png"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
 	"os"
+	"runtime"
+	"text/template"
+	"time"
 
 	"github.com/unixpickle/mustachemash/mustacher"
 )
 
+// StdioPath is used in place of a file path to read from
+// standard input or write to standard output.
+const StdioPath = "-"
+
+type Flags struct {
+	FacesPath    string
+	PlacerPath   string
+	ProfilesPath string
+	InPath       string
+	OutPath      string
+	Format       string
+	Quality      int
+	Verbose      bool
+	JSON         bool
+	Debug        bool
+
+	Style       string
+	Color       string
+	Opacity     float64
+	StrokeWidth float64
+	StrokeColor string
+	Scale       float64
+	Angle       float64
+	Aliased     bool
+	Realistic   bool
+
+	ScanStride  float64
+	MinFaceSize int
+	MaxFaces    int
+	MaxAnalysis int
+	Rotations   bool
+
+	Keyframes int
+
+	Batch        bool
+	OutDir       string
+	NameTemplate string
+	Workers      int
+}
+
 func main() {
-	if len(os.Args) != 5 {
-		fmt.Fprintf(os.Stderr, "Usage: %s faces.json placer in_img out_img\n",
-			os.Args[0])
-		os.Exit(1)
+	var f Flags
+	flag.StringVar(&f.FacesPath, "faces", "trained_data/cascade.json", "face cascade path")
+	flag.StringVar(&f.PlacerPath, "placer", "trained_data/placer", "placer network path")
+	flag.StringVar(&f.ProfilesPath, "profiles", "", "optional profile face cascade path")
+	flag.StringVar(&f.InPath, "in", StdioPath, "input image path, or - for stdin")
+	flag.StringVar(&f.OutPath, "out", StdioPath, "output image path, or - for stdout")
+	flag.StringVar(&f.Format, "format", "",
+		"output format: png, jpeg, or gif (default: from output extension, or png)")
+	flag.IntVar(&f.Quality, "quality", jpeg.DefaultQuality, "JPEG quality (1-100)")
+	flag.BoolVar(&f.Verbose, "v", false, "log progress to stderr")
+	flag.BoolVar(&f.JSON, "json", false, "output the detected matches as JSON instead of an image")
+	flag.BoolVar(&f.Debug, "debug", false, "output the image annotated with face detections "+
+		"and placements instead of mustaches")
+
+	flag.StringVar(&f.Style, "style", "",
+		"style name, SVG file, or image file (default "+mustacher.DefaultStyleName+")")
+	flag.StringVar(&f.Color, "color", "", "mustache color as #rrggbb (default: estimated)")
+	flag.Float64Var(&f.Opacity, "opacity", 1, "mustache opacity (0-1)")
+	flag.Float64Var(&f.StrokeWidth, "stroke-width", 0, "mustache outline width in pixels")
+	flag.StringVar(&f.StrokeColor, "stroke-color", "#000000", "mustache outline color as #rrggbb")
+	flag.Float64Var(&f.Scale, "scale", 1, "mustache size multiplier")
+	flag.Float64Var(&f.Angle, "angle", 0, "mustache angle offset in radians")
+	flag.BoolVar(&f.Aliased, "aliased", false, "disable anti-aliasing")
+	flag.BoolVar(&f.Realistic, "realistic", false, "draw textured, shaded mustaches")
+
+	defaultConfig := mustacher.DefaultDetectorConfig()
+	flag.Float64Var(&f.ScanStride, "scan-stride", defaultConfig.ScanStride,
+		"face scan scale step (larger is faster but may miss faces)")
+	flag.IntVar(&f.MinFaceSize, "min-face", 0, "minimum face width in pixels")
+	flag.IntVar(&f.MaxFaces, "max-faces", 0, "maximum number of faces to mustache (0 for no limit)")
+	flag.BoolVar(&f.Rotations, "rotations", false, "also scan rotated copies of the image "+
+		"to find tilted faces (slower)")
+	flag.IntVar(&f.MaxAnalysis, "max-analysis-size", 0, "detect faces in a downscaled copy "+
+		"of images larger than this many pixels across (0 for no limit)")
+
+	flag.IntVar(&f.Keyframes, "keyframes", 1, "for animated GIFs, detect faces in every Nth frame "+
+		"and interpolate in between")
+
+	flag.BoolVar(&f.Batch, "batch", false, "process the image files, directories, or glob patterns "+
+		"given as arguments")
+	flag.StringVar(&f.OutDir, "outdir", "mustached", "output directory for batch mode")
+	flag.StringVar(&f.NameTemplate, "name", "", "output file name template for batch mode "+
+		"(fields: Name, Ext, Index) (default {{.Name}}_stache with the output format's extension)")
+	flag.IntVar(&f.Workers, "workers", runtime.NumCPU(), "number of images to process at once "+
+		"in batch mode")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -batch [flags] input ...\n", os.Args[0])
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	detector, err := mustacher.LoadDetector(os.Args[1], os.Args[2])
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to load detector:", err)
+	if (!f.Batch && flag.NArg() != 0) || (f.Batch && flag.NArg() == 0) {
+		flag.Usage()
 		os.Exit(1)
 	}
+	if !f.Verbose {
+		log.SetOutput(ioutil.Discard)
+	}
 
-	inImg, err := readImage(os.Args[3])
+	drawOpts, err := f.DrawOptions()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to load input image:", err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	if !f.JSON && (!f.Batch || f.Format != "") {
+		if _, err := outputFormat(f.Format, f.OutPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if f.Keyframes < 1 {
+		fmt.Fprintln(os.Stderr, "Invalid keyframe interval:", f.Keyframes)
+		os.Exit(1)
+	}
+	if f.ScanStride <= 1 {
+		fmt.Fprintln(os.Stderr, "Invalid scan stride:", f.ScanStride)
+		os.Exit(1)
+	}
+	if f.Quality < 1 || f.Quality > 100 {
+		fmt.Fprintln(os.Stderr, "Invalid JPEG quality:", f.Quality)
+		os.Exit(1)
+	}
+
+	var outNames *template.Template
+	if f.Batch {
+		if f.NameTemplate == "" {
+			f.NameTemplate = "{{.Name}}_stache" + f.defaultExtension()
+		}
+		outNames, err = template.New("name").Parse(f.NameTemplate)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid name template:", err)
+			os.Exit(1)
+		}
+		if f.Workers < 1 {
+			fmt.Fprintln(os.Stderr, "Invalid worker count:", f.Workers)
+			os.Exit(1)
+		}
+	}
 
-	outImg := mustacher.Draw(inImg, detector.Match(inImg))
-	outFile, err := os.Create(os.Args[4])
+	log.Println("Loading detector...")
+	detector, err := mustacher.LoadDetector(f.FacesPath, f.PlacerPath)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to create output:", err)
+		fmt.Fprintln(os.Stderr, "Failed to load detector:", err)
 		os.Exit(1)
 	}
-	defer outFile.Close()
-	if err := png.Encode(outFile, outImg); err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to encode output:", err)
+	if f.ProfilesPath != "" {
+		if err := detector.LoadProfiles(f.ProfilesPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load profile cascade:", err)
+			os.Exit(1)
+		}
+	}
+	detector.Config = mustacher.DefaultDetectorConfig()
+	detector.Config.ScanStride = f.ScanStride
+	detector.Config.MinFaceSize = f.MinFaceSize
+	detector.Config.MaxFaces = f.MaxFaces
+	detector.Config.MaxAnalysisSize = f.MaxAnalysis
+	if f.Rotations {
+		detector.Config.ScanAngles = mustacher.RotationScanAngles()
+	}
+
+	if f.Batch {
+		if !runBatch(detector, &f, drawOpts, flag.Args(), outNames) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := processImage(detector, &f, drawOpts, f.InPath, f.OutPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func readImage(path string) (image.Image, error) {
-	imgFile, err := os.Open(path)
+// processImage mustaches an input file and writes the
+// result to an output file.
+//
+// Animated GIFs are mustached frame by frame if the
+// output is also a GIF.
+func processImage(d *mustacher.Detector, f *Flags, opts *mustacher.DrawOptions,
+	inPath, outPath string) error {
+	data, err := readInput(inPath)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("read input: %s", err)
+	}
+	if f.JSON {
+		img, _, err := mustacher.DecodeImage(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("decode input: %s", err)
+		}
+		if err := writeMatches(outPath, detect(d, img)); err != nil {
+			return fmt.Errorf("write output: %s", err)
+		}
+		return nil
+	}
+	if format, _ := outputFormat(f.Format, outPath); format == "gif" && !f.Debug {
+		anim, err := decodeAnimation(data)
+		if err != nil {
+			return fmt.Errorf("decode input: %s", err)
+		} else if anim != nil {
+			anim = mustacheAnimation(d, anim, opts, f.Keyframes)
+			if err := writeAnimation(outPath, anim); err != nil {
+				return fmt.Errorf("write output: %s", err)
+			}
+			return nil
+		}
 	}
-	defer imgFile.Close()
-	img, _, err := image.Decode(imgFile)
-	return img, err
+	img, _, err := mustacher.DecodeImage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decode input: %s", err)
+	}
+	var outImg image.Image
+	if f.Debug {
+		outImg = debugImage(d, img)
+	} else {
+		outImg = mustache(d, img, opts)
+	}
+	if err := writeImage(outPath, outImg, f); err != nil {
+		return fmt.Errorf("write output: %s", err)
+	}
+	return nil
+}
+
+func mustache(d *mustacher.Detector, img image.Image, opts *mustacher.DrawOptions) image.Image {
+	return mustacher.DrawWithOptions(img, detect(d, img), opts)
+}
+
+func debugImage(d *mustacher.Detector, img image.Image) image.Image {
+	start := time.Now()
+	info := d.MatchDebug(img)
+	log.Printf("Found %d raw faces and %d matches in %s.", len(info.RawFaces),
+		len(info.Matches), time.Since(start))
+	return mustacher.DrawDebug(img, info)
+}
+
+func detect(d *mustacher.Detector, img image.Image) []*mustacher.Match {
+	start := time.Now()
+	matches := d.Match(img)
+	log.Printf("Found %d matches in %s.", len(matches), time.Since(start))
+	return matches
 }
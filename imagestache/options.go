@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/unixpickle/mustachemash/mustacher"
+)
+
+// DrawOptions creates mustache drawing options from the
+// command-line flags.
+func (f *Flags) DrawOptions() (*mustacher.DrawOptions, error) {
+	res := &mustacher.DrawOptions{
+		Opacity:     f.Opacity,
+		StrokeWidth: f.StrokeWidth,
+		Scale:       f.Scale,
+		AngleOffset: f.Angle,
+		Aliased:     f.Aliased,
+		Realistic:   f.Realistic,
+	}
+	if f.Opacity <= 0 || f.Opacity > 1 {
+		return nil, fmt.Errorf("invalid opacity: %f", f.Opacity)
+	}
+	if f.Scale <= 0 {
+		return nil, fmt.Errorf("invalid scale: %f", f.Scale)
+	}
+
+	var err error
+	if f.Style != "" {
+		res.Style, err = mustacher.ParseStyle(f.Style)
+		if err != nil {
+			return nil, fmt.Errorf("load style: %s", err)
+		}
+	}
+	if f.Color != "" {
+		res.Color, err = mustacher.ParseColor(f.Color)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.StrokeColor, err = mustacher.ParseColor(f.StrokeColor)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,123 @@
+// Package imgtensor converts images into tensors which
+// can be fed to neural networks.
+package imgtensor
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/unixpickle/weakai/neuralnet"
+)
+
+// FromImage converts an image to a tensor with three
+// channels (red, green, and blue) whose values range from
+// 0 to 1.
+// Alpha is premultiplied, as it is by color.Color.RGBA.
+//
+// Common image types are converted directly from their
+// pixel buffers, which is much faster than calling At
+// for every pixel.
+// These conversions may differ from the generic one by
+// rounding error.
+func FromImage(img image.Image) *neuralnet.Tensor3 {
+	bounds := img.Bounds()
+	res := neuralnet.NewTensor3(bounds.Dx(), bounds.Dy(), 3)
+	switch img := img.(type) {
+	case *image.RGBA:
+		fromRGBA(res, img)
+	case *image.NRGBA:
+		fromNRGBA(res, img)
+	case *image.YCbCr:
+		fromYCbCr(res, img)
+	case *image.Gray:
+		fromGray(res, img)
+	default:
+		fromGeneric(res, img)
+	}
+	return res
+}
+
+// Crop copies a rectangle out of an image.
+// The resulting image's bounds start at (0, 0).
+func Crop(img image.Image, r image.Rectangle) *image.RGBA {
+	res := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(res, res.Bounds(), img, r.Min, draw.Src)
+	return res
+}
+
+func fromRGBA(t *neuralnet.Tensor3, img *image.RGBA) {
+	bounds := img.Bounds()
+	idx := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := img.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for c := 0; c < 3; c++ {
+				t.Data[idx] = float64(img.Pix[offset+c]) / 0xff
+				idx++
+			}
+			offset += 4
+		}
+	}
+}
+
+func fromNRGBA(t *neuralnet.Tensor3, img *image.NRGBA) {
+	bounds := img.Bounds()
+	idx := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := img.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			alpha := float64(img.Pix[offset+3]) / 0xff
+			for c := 0; c < 3; c++ {
+				t.Data[idx] = alpha * float64(img.Pix[offset+c]) / 0xff
+				idx++
+			}
+			offset += 4
+		}
+	}
+}
+
+func fromYCbCr(t *neuralnet.Tensor3, img *image.YCbCr) {
+	bounds := img.Bounds()
+	idx := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			yi := img.YOffset(x, y)
+			ci := img.COffset(x, y)
+			c := color.YCbCr{Y: img.Y[yi], Cb: img.Cb[ci], Cr: img.Cr[ci]}
+			r, g, b, _ := c.RGBA()
+			t.Data[idx] = float64(r) / 0xffff
+			t.Data[idx+1] = float64(g) / 0xffff
+			t.Data[idx+2] = float64(b) / 0xffff
+			idx += 3
+		}
+	}
+}
+
+func fromGray(t *neuralnet.Tensor3, img *image.Gray) {
+	bounds := img.Bounds()
+	idx := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := img.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			val := float64(img.Pix[offset]) / 0xff
+			t.Data[idx] = val
+			t.Data[idx+1] = val
+			t.Data[idx+2] = val
+			idx += 3
+			offset++
+		}
+	}
+}
+
+func fromGeneric(t *neuralnet.Tensor3, img image.Image) {
+	bounds := img.Bounds()
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, _ := img.At(x+bounds.Min.X, y+bounds.Min.Y).RGBA()
+			t.Set(x, y, 0, float64(r)/0xffff)
+			t.Set(x, y, 1, float64(g)/0xffff)
+			t.Set(x, y, 2, float64(b)/0xffff)
+		}
+	}
+}
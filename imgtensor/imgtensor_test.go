@@ -0,0 +1,81 @@
+package imgtensor
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/weakai/neuralnet"
+)
+
+func TestFromImageFastPaths(t *testing.T) {
+	rect := image.Rect(-3, 2, 14, 11)
+	sub := image.Rect(1, 4, 9, 10)
+	tests := []struct {
+		Name  string
+		Image image.Image
+	}{
+		{"RGBA", randomRGBA(rect)},
+		{"RGBASub", randomRGBA(rect).SubImage(sub)},
+		{"NRGBA", randomNRGBA(rect)},
+		{"NRGBASub", randomNRGBA(rect).SubImage(sub)},
+		{"YCbCr420", randomYCbCr(rect, image.YCbCrSubsampleRatio420)},
+		{"YCbCr420Sub", randomYCbCr(rect, image.YCbCrSubsampleRatio420).SubImage(sub)},
+		{"YCbCr444Sub", randomYCbCr(rect, image.YCbCrSubsampleRatio444).SubImage(sub)},
+		{"Gray", randomGray(rect)},
+		{"GraySub", randomGray(rect).SubImage(sub)},
+	}
+	for _, test := range tests {
+		bounds := test.Image.Bounds()
+		expected := neuralnet.NewTensor3(bounds.Dx(), bounds.Dy(), 3)
+		fromGeneric(expected, test.Image)
+		actual := FromImage(test.Image)
+		if actual.Width != expected.Width || actual.Height != expected.Height ||
+			actual.Depth != expected.Depth {
+			t.Errorf("%s: expected %dx%dx%d tensor but got %dx%dx%d", test.Name,
+				expected.Width, expected.Height, expected.Depth,
+				actual.Width, actual.Height, actual.Depth)
+			continue
+		}
+		for i, x := range expected.Data {
+			if math.Abs(x-actual.Data[i]) > 1e-4 {
+				t.Errorf("%s: component %d should be %f but got %f", test.Name, i, x,
+					actual.Data[i])
+				break
+			}
+		}
+	}
+}
+
+func randomRGBA(r image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(r)
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := rand.Intn(0x100)
+		img.Pix[i+3] = uint8(a)
+		for j := 0; j < 3; j++ {
+			img.Pix[i+j] = uint8(rand.Intn(a + 1))
+		}
+	}
+	return img
+}
+
+func randomNRGBA(r image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	rand.Read(img.Pix)
+	return img
+}
+
+func randomYCbCr(r image.Rectangle, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	img := image.NewYCbCr(r, ratio)
+	rand.Read(img.Y)
+	rand.Read(img.Cb)
+	rand.Read(img.Cr)
+	return img
+}
+
+func randomGray(r image.Rectangle) *image.Gray {
+	img := image.NewGray(r)
+	rand.Read(img.Pix)
+	return img
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/unixpickle/mustachemash/mustacher"
+)
+
+// These limits keep clients from making the server draw
+// enormous mustaches.
+const (
+	maxScale       = 4
+	maxStrokeWidth = 50
+)
+
+type Handler struct {
+	Detector *mustacher.Detector
+
+	// MaxBytes is the maximum size of an uploaded image.
+	MaxBytes int64
+
+	// MaxPixels is the maximum number of pixels in an
+	// uploaded image, checked before the image is decoded.
+	// If 0, there is no limit.
+	MaxPixels int64
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/mustache":
+		h.handleMustache(w, r)
+	case "/detect":
+		h.handleDetect(w, r)
+	case "/healthz":
+		w.Write([]byte("ok\n"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleMustache responds with a mustached copy of the
+// uploaded image.
+//
+// The query may specify the output format (png, jpeg, or
+// gif), the JPEG quality, and the drawing options
+// described in drawOptions.
+func (h *Handler) handleMustache(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	contentType, encode, err := imageEncoder(query.Get("format"), query.Get("quality"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := drawOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	img, ok := h.readImage(w, r)
+	if !ok {
+		return
+	}
+	matches, err := h.Detector.MatchContext(r.Context(), img)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	encode(w, mustacher.DrawWithOptions(img, matches, opts))
+}
+
+// handleDetect responds with the JSON-encoded matches for
+// the uploaded image.
+func (h *Handler) handleDetect(w http.ResponseWriter, r *http.Request) {
+	img, ok := h.readImage(w, r)
+	if !ok {
+		return
+	}
+	matches, err := h.Detector.MatchContext(r.Context(), img)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if matches == nil {
+		matches = []*mustacher.Match{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
+
+// readImage decodes the image from a POST request.
+//
+// The image may be the raw request body, or an "image"
+// field in a multipart form.
+// If the image cannot be read, an error is sent to the
+// client and false is returned.
+func (h *Handler) readImage(w http.ResponseWriter, r *http.Request) (image.Image, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBytes)
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			readError(w, "read image field", err)
+			return nil, false
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		readError(w, "read image", err)
+		return nil, false
+	}
+
+	// Check the size first so that a small, highly
+	// compressed upload cannot make us allocate a huge
+	// image.
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "decode image: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if h.MaxPixels > 0 && int64(config.Width)*int64(config.Height) > h.MaxPixels {
+		http.Error(w, fmt.Sprintf("image too large: %dx%d", config.Width, config.Height),
+			http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	img, _, err := mustacher.DecodeImage(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "decode image: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return img, true
+}
+
+// readError sends an error for a failed read of the
+// request body.
+func readError(w http.ResponseWriter, context string, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, context+": "+err.Error(), http.StatusBadRequest)
+}
+
+// imageEncoder finds the content type and encoder for an
+// output format and JPEG quality.
+func imageEncoder(format, quality string) (string, func(io.Writer, image.Image) error,
+	error) {
+	switch strings.ToLower(format) {
+	case "", "png":
+		return "image/png", png.Encode, nil
+	case "jpg", "jpeg":
+		q := jpeg.DefaultQuality
+		if quality != "" {
+			var err error
+			q, err = strconv.Atoi(quality)
+			if err != nil || q < 1 || q > 100 {
+				return "", nil, errors.New("invalid quality: " + quality)
+			}
+		}
+		return "image/jpeg", func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: q})
+		}, nil
+	case "gif":
+		return "image/gif", func(w io.Writer, img image.Image) error {
+			return gif.Encode(w, img, nil)
+		}, nil
+	}
+	return "", nil, errors.New("unsupported format: " + format)
+}
+
+// drawOptions creates drawing options from the query
+// parameters style, color, opacity, stroke-width,
+// stroke-color, scale, angle (in radians), aliased, and
+// realistic.
+// The scale may be at most maxScale, and the stroke width
+// at most maxStrokeWidth pixels.
+//
+// Styles must be registered names, since clients should
+// not be able to load files from the server.
+func drawOptions(query url.Values) (*mustacher.DrawOptions, error) {
+	res := &mustacher.DrawOptions{}
+	var err error
+	if name := query.Get("style"); name != "" {
+		if filepath.Ext(name) != "" {
+			return nil, errors.New("style files are not supported: " + name)
+		}
+		res.Style, err = mustacher.ParseStyle(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c := query.Get("color"); c != "" {
+		res.Color, err = mustacher.ParseColor(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c := query.Get("stroke-color"); c != "" {
+		res.StrokeColor, err = mustacher.ParseColor(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	floatParams := []struct {
+		Name  string
+		Dest  *float64
+		Valid func(x float64) bool
+	}{
+		{"opacity", &res.Opacity, func(x float64) bool { return x > 0 && x <= 1 }},
+		{"stroke-width", &res.StrokeWidth, func(x float64) bool {
+			return x >= 0 && x <= maxStrokeWidth
+		}},
+		{"scale", &res.Scale, func(x float64) bool { return x > 0 && x <= maxScale }},
+		{"angle", &res.AngleOffset, func(x float64) bool { return true }},
+	}
+	for _, param := range floatParams {
+		if s := query.Get(param.Name); s != "" {
+			x, err := strconv.ParseFloat(s, 64)
+			if err != nil || math.IsNaN(x) || math.IsInf(x, 0) || !param.Valid(x) {
+				return nil, fmt.Errorf("invalid %s: %s", param.Name, s)
+			}
+			*param.Dest = x
+		}
+	}
+
+	boolParams := []struct {
+		Name string
+		Dest *bool
+	}{
+		{"aliased", &res.Aliased},
+		{"realistic", &res.Realistic},
+	}
+	for _, param := range boolParams {
+		if s := query.Get(param.Name); s != "" {
+			*param.Dest, err = strconv.ParseBool(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s flag: %s", param.Name, s)
+			}
+		}
+	}
+	return res, nil
+}
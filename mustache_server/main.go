@@ -0,0 +1,72 @@
+// Command mustache_server serves an HTTP API for adding
+// mustaches to images.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/unixpickle/mustachemash/mustacher"
+)
+
+func main() {
+	var addr, facesPath, placerPath, profilesPath string
+	var maxBytes, maxPixels int64
+	var maxAnalysis int
+	var readTimeout, writeTimeout, handlerTimeout time.Duration
+	flag.StringVar(&addr, "addr", ":8080", "address to listen on")
+	flag.StringVar(&facesPath, "faces", "trained_data/cascade.json", "face cascade path")
+	flag.StringVar(&placerPath, "placer", "trained_data/placer", "placer network path")
+	flag.StringVar(&profilesPath, "profiles", "", "optional profile face cascade path")
+	flag.Int64Var(&maxBytes, "max-size", 10<<20, "maximum request body size in bytes")
+	flag.Int64Var(&maxPixels, "max-pixels", 50000000, "maximum image size in pixels "+
+		"(0 for no limit)")
+	flag.IntVar(&maxAnalysis, "max-analysis-size", 1600, "detect faces in a downscaled copy "+
+		"of images larger than this many pixels across (0 for no limit)")
+	flag.DurationVar(&readTimeout, "read-timeout", 30*time.Second, "timeout for reading requests")
+	flag.DurationVar(&writeTimeout, "write-timeout", 90*time.Second,
+		"timeout for writing responses")
+	flag.DurationVar(&handlerTimeout, "timeout", 60*time.Second,
+		"timeout for processing a request")
+	flag.Parse()
+
+	if flag.NArg() != 0 || maxBytes <= 0 || maxPixels < 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	log.Println("Loading detector...")
+	detector, err := mustacher.LoadDetector(facesPath, placerPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load detector:", err)
+		os.Exit(1)
+	}
+	if profilesPath != "" {
+		if err := detector.LoadProfiles(profilesPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load profile cascade:", err)
+			os.Exit(1)
+		}
+	}
+	detector.Config = mustacher.DefaultDetectorConfig()
+	detector.Config.MaxAnalysisSize = maxAnalysis
+
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.TimeoutHandler(&Handler{
+			Detector:  detector,
+			MaxBytes:  maxBytes,
+			MaxPixels: maxPixels,
+		}, handlerTimeout, "request timed out"),
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+	log.Println("Listening on", addr)
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
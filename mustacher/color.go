@@ -0,0 +1,152 @@
+package mustacher
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	hairSampleGrid = 24
+
+	// hairSkinDistance is how close, as a fraction of the
+	// color range, a sample may be to the skin color
+	// before it is assumed to be skin rather than hair.
+	hairSkinDistance = 0.12
+
+	// hairMinSamples is the fraction of samples which
+	// must remain after removing skin for them to be
+	// trusted.
+	hairMinSamples = 0.1
+)
+
+// ParseColor parses a color of the form #rrggbb or #rgb.
+// The leading # is optional.
+func ParseColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return nil, errors.New("invalid color: " + s)
+	}
+	num, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, errors.New("invalid color: " + s)
+	}
+	return color.RGBA{
+		R: uint8(num >> 16),
+		G: uint8(num >> 8),
+		B: uint8(num),
+		A: 0xff,
+	}, nil
+}
+
+// estimateHairColor guesses the color of a person's hair
+// by sampling their eyebrows and the hair just above
+// their forehead.
+//
+// Samples that look like the skin on the person's cheeks
+// are ignored, and the median of the remaining samples
+// (by brightness) is used, so that a few dark pixels
+// from eyes or shadows do not skew the estimate.
+//
+// The face rectangle is in the coordinate system of img.
+func estimateHairColor(img image.Image, face image.Rectangle) color.Color {
+	w := float64(face.Dx())
+	h := float64(face.Dy())
+	hairRegions := []image.Rectangle{
+		// Eyebrows.
+		image.Rect(face.Min.X+int(w*0.15), face.Min.Y+int(h*0.2),
+			face.Min.X+int(w*0.85), face.Min.Y+int(h*0.38)),
+
+		// Hair above the forehead.
+		image.Rect(face.Min.X+int(w*0.2), face.Min.Y-int(h*0.15),
+			face.Min.X+int(w*0.8), face.Min.Y+int(h*0.02)),
+	}
+	skinRegions := []image.Rectangle{
+		// Cheeks.
+		image.Rect(face.Min.X+int(w*0.2), face.Min.Y+int(h*0.5),
+			face.Min.X+int(w*0.35), face.Min.Y+int(h*0.65)),
+		image.Rect(face.Min.X+int(w*0.65), face.Min.Y+int(h*0.5),
+			face.Min.X+int(w*0.8), face.Min.Y+int(h*0.65)),
+	}
+
+	samples := sampleRegions(img, hairRegions)
+	if len(samples) == 0 {
+		return color.Black
+	}
+	if skin := sampleRegions(img, skinRegions); len(skin) > 0 {
+		skinColor := medianColor(skin)
+		var hair []color.RGBA64
+		for _, s := range samples {
+			if colorDistance(s, skinColor) > hairSkinDistance {
+				hair = append(hair, s)
+			}
+		}
+		if float64(len(hair)) >= float64(len(samples))*hairMinSamples {
+			samples = hair
+		}
+	}
+
+	c := medianColor(samples)
+	return color.RGBA{
+		R: uint8(c.R >> 8),
+		G: uint8(c.G >> 8),
+		B: uint8(c.B >> 8),
+		A: 0xff,
+	}
+}
+
+// sampleRegions samples opaque colors on a grid in each
+// region.
+func sampleRegions(img image.Image, regions []image.Rectangle) []color.RGBA64 {
+	var samples []color.RGBA64
+	for _, region := range regions {
+		region = region.Intersect(img.Bounds())
+		if region.Empty() {
+			continue
+		}
+		stepX := region.Dx()/hairSampleGrid + 1
+		stepY := region.Dy()/hairSampleGrid + 1
+		for y := region.Min.Y; y < region.Max.Y; y += stepY {
+			for x := region.Min.X; x < region.Max.X; x += stepX {
+				r, g, b, _ := img.At(x, y).RGBA()
+				samples = append(samples, color.RGBA64{
+					R: uint16(r),
+					G: uint16(g),
+					B: uint16(b),
+					A: 0xffff,
+				})
+			}
+		}
+	}
+	return samples
+}
+
+// medianColor finds the sample with the median
+// luminance.
+func medianColor(samples []color.RGBA64) color.RGBA64 {
+	sorted := append([]color.RGBA64{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return luminance(sorted[i]) < luminance(sorted[j])
+	})
+	return sorted[len(sorted)/2]
+}
+
+// colorDistance computes the Euclidean distance between
+// two colors, where each channel ranges from 0 to 1.
+func colorDistance(c1, c2 color.RGBA64) float64 {
+	dr := (float64(c1.R) - float64(c2.R)) / 0xffff
+	dg := (float64(c1.G) - float64(c2.G)) / 0xffff
+	db := (float64(c1.B) - float64(c2.B)) / 0xffff
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+func luminance(c color.RGBA64) float64 {
+	return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+}
@@ -0,0 +1,109 @@
+package mustacher
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/llgcode/draw2d"
+	"github.com/llgcode/draw2d/draw2dimg"
+)
+
+var (
+	debugRawColor    = color.RGBA{R: 0xff, G: 0xff, A: 0xff}
+	debugFaceColor   = color.RGBA{G: 0xff, A: 0xff}
+	debugCropColor   = color.RGBA{G: 0xff, B: 0xff, A: 0xff}
+	debugCenterColor = color.RGBA{R: 0xff, A: 0xff}
+	debugAxisColor   = color.RGBA{R: 0xff, B: 0xff, A: 0xff}
+)
+
+// DebugInfo records the intermediate results of the
+// detection pipeline for an image.
+// All rectangles are in the image's coordinate system.
+type DebugInfo struct {
+	// RawFaces contains every face cascade hit, before
+	// overlapping hits were joined.
+	RawFaces []image.Rectangle
+
+	// Matches contains the final matches.
+	// The face boxes which the cascade hits were joined
+	// into are stored in each match's Face field.
+	Matches []*Match
+
+	// PlacerCrops maps each match to the scaled face
+	// image which was fed to the placer network.
+	PlacerCrops map[*Match]image.Image
+}
+
+// DrawDebug annotates an image with the results of
+// Detector.MatchDebug.
+//
+// Raw cascade hits are outlined in yellow, and the
+// joined face boxes in green.
+// Each face's placer crop is drawn in its top-left
+// corner.
+// The predicted mustache center is marked in red, and
+// the mustache's orientation is drawn in magenta, with a
+// long line along the mustache and a short line pointing
+// toward the chin.
+//
+// The resulting image has the same bounds as img.
+func DrawDebug(img image.Image, info *DebugInfo) image.Image {
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Src)
+
+	ctx := draw2dimg.NewGraphicContext(canvas)
+	ctx.Translate(-float64(bounds.Min.X), -float64(bounds.Min.Y))
+
+	ctx.SetLineWidth(1)
+	ctx.SetStrokeColor(debugRawColor)
+	for _, r := range info.RawFaces {
+		debugRect(ctx, r)
+	}
+
+	for _, m := range info.Matches {
+		ctx.SetLineWidth(2)
+		ctx.SetStrokeColor(debugFaceColor)
+		debugRect(ctx, m.Face)
+
+		if crop, ok := info.PlacerCrops[m]; ok {
+			cropRect := crop.Bounds().Sub(crop.Bounds().Min).Add(m.Face.Min.Sub(bounds.Min))
+			draw.Draw(canvas, cropRect, crop, crop.Bounds().Min, draw.Src)
+			ctx.SetLineWidth(1)
+			ctx.SetStrokeColor(debugCropColor)
+			debugRect(ctx, cropRect.Add(bounds.Min))
+		}
+
+		cos, sin := math.Cos(m.Angle), math.Sin(m.Angle)
+		ctx.SetLineWidth(2)
+		ctx.SetStrokeColor(debugAxisColor)
+		ctx.BeginPath()
+		ctx.MoveTo(m.X-cos*m.Radius, m.Y-sin*m.Radius)
+		ctx.LineTo(m.X+cos*m.Radius, m.Y+sin*m.Radius)
+		ctx.MoveTo(m.X, m.Y)
+		ctx.LineTo(m.X-sin*m.Radius/2, m.Y+cos*m.Radius/2)
+		ctx.Stroke()
+
+		ctx.SetFillColor(debugCenterColor)
+		ctx.BeginPath()
+		ctx.ArcTo(m.X, m.Y, 3, 3, 0, 2*math.Pi)
+		ctx.Close()
+		ctx.Fill()
+	}
+
+	res := image.NewRGBA(bounds)
+	draw.Draw(res, bounds, canvas, image.ZP, draw.Src)
+	return res
+}
+
+func debugRect(ctx draw2d.GraphicContext, r image.Rectangle) {
+	ctx.BeginPath()
+	ctx.MoveTo(float64(r.Min.X), float64(r.Min.Y))
+	ctx.LineTo(float64(r.Max.X), float64(r.Min.Y))
+	ctx.LineTo(float64(r.Max.X), float64(r.Max.Y))
+	ctx.LineTo(float64(r.Min.X), float64(r.Max.Y))
+	ctx.Close()
+	ctx.Stroke()
+}
@@ -1,26 +1,115 @@
 package mustacher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
+	"image/color"
 	"io/ioutil"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/nfnt/resize"
 	"github.com/unixpickle/autofunc"
 	"github.com/unixpickle/haar"
+	"github.com/unixpickle/mustachemash/imgtensor"
 	"github.com/unixpickle/weakai/neuralnet"
 )
 
-const (
-	faceOverlapThreshold = 0.7
-	faceScanStride       = 1.5
-	placerImageSize      = 28
-)
+// DetectorConfig controls how a Detector searches for
+// faces.
+// Scanning with a larger stride or a larger minimum face
+// size is faster, but more faces are missed.
+type DetectorConfig struct {
+	// OverlapThreshold is the fraction by which raw face
+	// matches must overlap to be joined into one face.
+	// It must be in (0, 1].
+	// If it is 0, the default is used.
+	OverlapThreshold float64
+
+	// ScanStride is the factor by which the scanning
+	// window grows between scales.
+	// It must be greater than 1.
+	// If it is 0, the default is used.
+	ScanStride float64
+
+	// ScanStartScale is the initial scale of the scanning
+	// window, relative to the face cascade's window size.
+	// If it is 0, the cascade's window size is used.
+	// It must not be negative.
+	ScanStartScale float64
+
+	// PlacerImageSize is the side length of the face
+	// images fed to the placer network.
+	// It must match the size the placer was trained on.
+	// It must not be negative.
+	// If it is 0, the default is used.
+	PlacerImageSize int
+
+	// MinFaceSize and MaxFaceSize limit the width of the
+	// faces that are detected, in pixels.
+	// A value of 0 means no limit.
+	MinFaceSize int
+	MaxFaceSize int
+
+	// MaxFaces is the maximum number of faces to return.
+	// If more faces are found, the ones with the highest
+	// Confidence are kept.
+	// A value of 0 means no limit.
+	MaxFaces int
+
+	// Workers is the number of goroutines used to process
+	// faces concurrently.
+	// If it is 0, runtime.NumCPU() is used.
+	// It must not be negative.
+	Workers int
+
+	// ScanAngles lists angles, in radians, by which to
+	// rotate the image for additional scans, in order to
+	// find tilted faces.
+	// The upright image is always scanned.
+	// Matches found in several scans are merged, keeping
+	// the one with the highest Confidence.
+	// See RotationScanAngles for a typical set of angles.
+	ScanAngles []float64
+
+	// MaxAnalysisSize limits the resolution at which faces
+	// are detected.
+	// If an image's width or height exceeds it, faces are
+	// detected in a downscaled copy of the image, and the
+	// matches are mapped back to the full image.
+	// A value of 0 means no limit.
+	MaxAnalysisSize int
+
+	// PlacerBatchSize is the number of faces passed
+	// through the placer network at once.
+	// If it is 0, all faces are passed at once.
+	// It must not be negative.
+	PlacerBatchSize int
+}
+
+// DefaultDetectorConfig returns the configuration which a
+// Detector uses when its Config is nil.
+// It also supplies the values of zero fields in a
+// non-nil Config, where noted.
+func DefaultDetectorConfig() *DetectorConfig {
+	return &DetectorConfig{
+		OverlapThreshold: 0.7,
+		ScanStride:       1.5,
+		PlacerImageSize:  28,
+		PlacerBatchSize:  16,
+	}
+}
 
 // A Match represents the destination for a mustache.
 type Match struct {
-	// These are the coordinates of the mustache's center.
+	// These are the coordinates of the mustache's center,
+	// in the coordinate system of the image it was found
+	// in (i.e. relative to the image's bounds, not to its
+	// top-left corner).
 	X float64
 	Y float64
 
@@ -29,6 +118,57 @@ type Match struct {
 
 	// Angle is the rotation of the mustache in radians.
 	Angle float64
+
+	// Style is the name of a registered style to use for
+	// this mustache.
+	// If empty, the style is chosen by the caller of Draw.
+	Style string
+
+	// Color is the suggested color for the mustache,
+	// based on the person's hair color.
+	// If nil, mustaches are drawn in black.
+	Color color.Color
+
+	// Face is the face detected by the face cascade, in
+	// the same coordinate system as X and Y.
+	// If the face was found in a rotated copy of the
+	// image, Face is the bounding box of the rotated face.
+	Face image.Rectangle
+
+	// Confidence is the number of raw face cascade hits
+	// which were merged into Face (more precisely, the
+	// number of hits of a similar size centered in Face).
+	// Real faces tend to trigger the cascade many times
+	// at nearby positions and scales, whereas false
+	// positives tend to trigger it only a few times.
+	Confidence float64
+
+	// PlacerOutput is the raw output of the placer
+	// network, from which X, Y, Radius, and Angle were
+	// computed.
+	// The first two values are the mustache's position
+	// and the third its radius, all as fractions of the
+	// face's width; the fourth is the angle.
+	// For profile faces, it is nil, since their mustaches
+	// are placed without the network.
+	PlacerOutput []float64
+
+	// View indicates whether the face is frontal or a
+	// profile.
+	// Mustaches on profile faces are drawn from the side.
+	View FaceView
+}
+
+// FilterConfidence returns the matches whose Confidence
+// is at least minConfidence.
+func FilterConfidence(matches []*Match, minConfidence float64) []*Match {
+	var res []*Match
+	for _, m := range matches {
+		if m.Confidence >= minConfidence {
+			res = append(res, m)
+		}
+	}
+	return res
 }
 
 // A Detector uses a face cascade, a nose-mouth classifier,
@@ -36,6 +176,17 @@ type Match struct {
 type Detector struct {
 	Faces  *haar.Cascade
 	Placer neuralnet.Network
+
+	// Profiles is an optional cascade which detects faces
+	// in profile, looking toward the right side of the
+	// image.
+	// Faces looking to the left are found by scanning a
+	// mirrored copy of the image.
+	Profiles *haar.Cascade
+
+	// Config is the configuration for face detection.
+	// If it is nil, DefaultDetectorConfig is used.
+	Config *DetectorConfig
 }
 
 // LoadDetector loads a detector from the filesystem,
@@ -63,40 +214,440 @@ func LoadDetector(facesPath, placerPath string) (*Detector, error) {
 
 // Match finds all of the mustache destinations in
 // an image.
+//
+// Match panics if the detector's Config is invalid.
+// MatchContext returns an error instead.
 func (d *Detector) Match(img image.Image) []*Match {
+	matches, err := d.match(context.Background(), img, nil)
+	if err != nil {
+		panic(err)
+	}
+	return matches
+}
+
+// MatchContext is like Match, but it stops early if ctx
+// is done.
+// In that case, it returns the matches which were
+// finished before the cancellation, along with ctx.Err().
+//
+// The face cascades are run one scale at a time, and a
+// scale cannot be interrupted once it has started.
+// Thus, MatchContext may keep running for the duration
+// of one scale after ctx is done.
+func (d *Detector) MatchContext(ctx context.Context, img image.Image) ([]*Match, error) {
+	return d.match(ctx, img, nil)
+}
+
+// MatchDebug is like Match, but it also records the
+// intermediate results of the detection pipeline, which
+// can be rendered with DrawDebug.
+//
+// Like Match, it panics if the Config is invalid.
+func (d *Detector) MatchDebug(img image.Image) *DebugInfo {
+	info := &DebugInfo{PlacerCrops: map[*Match]image.Image{}}
+	var err error
+	info.Matches, err = d.match(context.Background(), img, info)
+	if err != nil {
+		panic(err)
+	}
+	return info
+}
+
+// match implements MatchContext, recording intermediate
+// results in debug if it is non-nil.
+func (d *Detector) match(ctx context.Context, img image.Image,
+	debug *DebugInfo) ([]*Match, error) {
+	config := d.config()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if scale := analysisScale(img.Bounds(), config.MaxAnalysisSize); scale < 1 {
+		return d.matchDownscaled(ctx, img, debug, scale)
+	}
+	if len(config.ScanAngles) > 0 {
+		return d.matchRotated(ctx, img, debug)
+	}
+	placerSize := config.PlacerImageSize
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	dualImage := haar.NewDualImage(haar.ImageIntegralImage(img))
-	faceMatches := d.Faces.Scan(dualImage, 0, faceScanStride)
-	faceMatches = faceMatches.JoinOverlaps(faceOverlapThreshold)
-
-	matches := make([]*Match, len(faceMatches))
-	for i, m := range faceMatches {
-		cropped := image.NewRGBA(image.Rect(0, 0, m.Width, m.Height))
-		for y := 0; y < m.Height; y++ {
-			for x := 0; x < m.Width; x++ {
-				cropped.Set(x, y, img.At(x+m.X+img.Bounds().Min.X,
-					y+m.Y+img.Bounds().Min.Y))
+	rawMatches, err := scanCascade(ctx, d.Faces, dualImage, startScale(config, d.Faces),
+		config.ScanStride)
+	if err != nil {
+		return nil, err
+	}
+	faceMatches := rawMatches.JoinOverlaps(config.OverlapThreshold)
+
+	rawRects := make([]image.Rectangle, len(rawMatches))
+	for i, m := range rawMatches {
+		rawRects[i] = image.Rect(m.X, m.Y, m.X+m.Width, m.Y+m.Height).Add(img.Bounds().Min)
+	}
+	if debug != nil {
+		debug.RawFaces = rawRects
+	}
+
+	var faces []image.Rectangle
+	for _, m := range faceMatches {
+		if (config.MinFaceSize > 0 && m.Width < config.MinFaceSize) ||
+			(config.MaxFaceSize > 0 && m.Width > config.MaxFaceSize) {
+			continue
+		}
+		faceRect := image.Rect(m.X, m.Y, m.X+m.Width, m.Y+m.Height).Add(img.Bounds().Min)
+		faces = append(faces, faceRect)
+	}
+
+	workers := config.Workers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+
+	matches := make([]*Match, len(faces))
+	crops := make([]image.Image, len(faces))
+	inputs := make([][]float64, len(faces))
+	parallelFor(ctx, len(faces), workers, func(i int) {
+		faceRect := faces[i]
+		crops[i], inputs[i] = placerInput(img, faceRect, placerSize)
+		matches[i] = &Match{
+			Color:      estimateHairColor(img, faceRect),
+			Face:       faceRect,
+			Confidence: faceConfidence(rawRects, faceRect),
+		}
+	})
+
+	batchSize := config.PlacerBatchSize
+	if batchSize == 0 {
+		batchSize = len(faces)
+	}
+	numBatches := 0
+	if batchSize > 0 {
+		numBatches = (len(faces) + batchSize - 1) / batchSize
+	}
+	placed := make([]bool, len(faces))
+	parallelFor(ctx, numBatches, workers, func(batch int) {
+		start := batch * batchSize
+		end := start + batchSize
+		if end > len(faces) {
+			end = len(faces)
+		}
+		for _, in := range inputs[start:end] {
+			if in == nil {
+				// The face was skipped due to cancellation.
+				return
 			}
 		}
-		scale := float64(cropped.Bounds().Dx()) / placerImageSize
-		scaled := resize.Resize(placerImageSize, placerImageSize, cropped, resize.Bilinear)
-		inTensor := neuralnet.NewTensor3(placerImageSize, placerImageSize, 3)
-		for y := 0; y < scaled.Bounds().Dy(); y++ {
-			for x := 0; x < scaled.Bounds().Dx(); x++ {
-				r, g, b, _ := scaled.At(x+scaled.Bounds().Min.X,
-					y+scaled.Bounds().Min.Y).RGBA()
-				inTensor.Set(x, y, 0, float64(r)/0xffff)
-				inTensor.Set(x, y, 1, float64(g)/0xffff)
-				inTensor.Set(x, y, 2, float64(b)/0xffff)
+		var joined []float64
+		for _, in := range inputs[start:end] {
+			joined = append(joined, in...)
+		}
+		outs := d.Placer.BatchLearner().Batch(&autofunc.Variable{Vector: joined},
+			end-start).Output()
+		outSize := len(outs) / (end - start)
+		for i := start; i < end; i++ {
+			out := outs[(i-start)*outSize : (i-start+1)*outSize]
+			faceRect := faces[i]
+			scale := float64(faceRect.Dx())
+			match := matches[i]
+			match.X = out[0]*scale + float64(faceRect.Min.X)
+			match.Y = out[1]*scale + float64(faceRect.Min.Y)
+			match.Radius = out[2] * scale
+			match.Angle = out[3]
+			match.PlacerOutput = append([]float64{}, out...)
+			placed[i] = true
+		}
+	})
+
+	var finished []*Match
+	for i, match := range matches {
+		if !placed[i] {
+			continue
+		}
+		finished = append(finished, match)
+		if debug != nil {
+			debug.PlacerCrops[match] = crops[i]
+		}
+	}
+	matches = finished
+
+	if d.Profiles != nil && ctx.Err() == nil {
+		profiles, profileRects, err := d.profileMatches(ctx, img, config, faces)
+		if err != nil {
+			return matches, err
+		}
+		matches = append(matches, profiles...)
+		if debug != nil {
+			debug.RawFaces = append(debug.RawFaces, profileRects...)
+		}
+	}
+
+	if config.MaxFaces > 0 && len(matches) > config.MaxFaces {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].Confidence > matches[j].Confidence
+		})
+		matches = matches[:config.MaxFaces]
+	}
+
+	return matches, ctx.Err()
+}
+
+// matchDownscaled runs match on a downscaled copy of an
+// image and maps the results back to the full image.
+func (d *Detector) matchDownscaled(ctx context.Context, img image.Image, debug *DebugInfo,
+	scale float64) ([]*Match, error) {
+	config := *d.config()
+	config.MaxAnalysisSize = 0
+	config.MinFaceSize = int(float64(config.MinFaceSize) * scale)
+	config.MaxFaceSize = int(math.Ceil(float64(config.MaxFaceSize) * scale))
+	smallDetector := &Detector{Faces: d.Faces, Placer: d.Placer, Profiles: d.Profiles,
+		Config: &config}
+
+	bounds := img.Bounds()
+	small := resize.Resize(uint(math.Max(1, math.Floor(float64(bounds.Dx())*scale+0.5))),
+		uint(math.Max(1, math.Floor(float64(bounds.Dy())*scale+0.5))), img, resize.Bilinear)
+	scaleX := float64(bounds.Dx()) / float64(small.Bounds().Dx())
+	scaleY := float64(bounds.Dy()) / float64(small.Bounds().Dy())
+	smallMin := small.Bounds().Min
+
+	mapRect := func(r image.Rectangle) image.Rectangle {
+		r = r.Sub(smallMin)
+		return image.Rect(
+			int(float64(r.Min.X)*scaleX), int(float64(r.Min.Y)*scaleY),
+			int(math.Ceil(float64(r.Max.X)*scaleX)), int(math.Ceil(float64(r.Max.Y)*scaleY)),
+		).Add(bounds.Min)
+	}
+
+	matches, err := smallDetector.match(ctx, small, debug)
+	for _, m := range matches {
+		m.X = (m.X-float64(smallMin.X))*scaleX + float64(bounds.Min.X)
+		m.Y = (m.Y-float64(smallMin.Y))*scaleY + float64(bounds.Min.Y)
+		m.Radius *= scaleX
+		m.Face = mapRect(m.Face)
+	}
+	if debug != nil {
+		for i, r := range debug.RawFaces {
+			debug.RawFaces[i] = mapRect(r)
+		}
+	}
+	return matches, err
+}
+
+// matchRotated runs match on the image and on rotated
+// copies of it, and merges the results.
+func (d *Detector) matchRotated(ctx context.Context, img image.Image,
+	debug *DebugInfo) ([]*Match, error) {
+	config := *d.config()
+	config.ScanAngles = nil
+	config.MaxFaces = 0
+	subDetector := &Detector{Faces: d.Faces, Placer: d.Placer, Profiles: d.Profiles,
+		Config: &config}
+
+	var subDebug *DebugInfo
+	if debug != nil {
+		subDebug = &DebugInfo{PlacerCrops: debug.PlacerCrops}
+	}
+
+	allMatches, err := subDetector.match(ctx, img, subDebug)
+	if debug != nil {
+		debug.RawFaces = subDebug.RawFaces
+	}
+	for _, angle := range d.config().ScanAngles {
+		if err != nil {
+			break
+		}
+		rotation := newImageRotation(img.Bounds(), angle)
+		var matches []*Match
+		matches, err = subDetector.match(ctx, rotation.Rotate(img), subDebug)
+		for _, m := range matches {
+			m.X, m.Y = rotation.ToOriginal(m.X, m.Y)
+			m.Angle += angle
+			m.Face = rotation.RectToOriginal(m.Face)
+		}
+		allMatches = append(allMatches, matches...)
+		if debug != nil {
+			for _, r := range subDebug.RawFaces {
+				debug.RawFaces = append(debug.RawFaces, rotation.RectToOriginal(r))
 			}
 		}
-		out := d.Placer.Apply(&autofunc.Variable{Vector: inTensor.Data}).Output()
-		matches[i] = &Match{
-			X:      out[0]*placerImageSize*scale + float64(m.X),
-			Y:      out[1]*placerImageSize*scale + float64(m.Y),
-			Radius: out[2] * placerImageSize * scale,
-			Angle:  out[3],
+	}
+
+	// Keep the most confident of each group of nearby
+	// matches.
+	sort.SliceStable(allMatches, func(i, j int) bool {
+		return allMatches[i].Confidence > allMatches[j].Confidence
+	})
+	var res []*Match
+	for _, m := range allMatches {
+		duplicate := false
+		for _, kept := range res {
+			dist := math.Hypot(m.X-kept.X, m.Y-kept.Y)
+			if dist < math.Max(m.Radius, kept.Radius) {
+				duplicate = true
+				break
+			}
 		}
+		if !duplicate {
+			res = append(res, m)
+		}
+	}
+	if maxFaces := d.config().MaxFaces; maxFaces > 0 && len(res) > maxFaces {
+		res = res[:maxFaces]
 	}
+	return res, err
+}
 
-	return matches
+// analysisScale computes the factor by which an image
+// must be downscaled to fit within maxSize.
+func analysisScale(bounds image.Rectangle, maxSize int) float64 {
+	size := bounds.Dx()
+	if bounds.Dy() > size {
+		size = bounds.Dy()
+	}
+	if maxSize <= 0 || size <= maxSize {
+		return 1
+	}
+	return float64(maxSize) / float64(size)
+}
+
+// placerInput crops a face out of an image and scales it
+// to the placer's input size.
+// It returns the scaled face and the corresponding input
+// vector for the placer.
+func placerInput(img image.Image, faceRect image.Rectangle, size int) (image.Image, []float64) {
+	cropped := imgtensor.Crop(img, faceRect)
+	scaled := resize.Resize(uint(size), uint(size), cropped, resize.Bilinear)
+	return scaled, imgtensor.FromImage(scaled).Data
+}
+
+// parallelFor calls f for every index from 0 to n-1,
+// using at most workers goroutines at once.
+// Once ctx is done, no more calls are started.
+func parallelFor(ctx context.Context, n, workers int, f func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	indices := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				if ctx.Err() != nil {
+					return
+				}
+				f(idx)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// config returns the detector's configuration, filling
+// in zero fields from DefaultDetectorConfig.
+func (d *Detector) config() *DetectorConfig {
+	defaults := DefaultDetectorConfig()
+	if d.Config == nil {
+		return defaults
+	}
+	res := *d.Config
+	if res.OverlapThreshold == 0 {
+		res.OverlapThreshold = defaults.OverlapThreshold
+	}
+	if res.ScanStride == 0 {
+		res.ScanStride = defaults.ScanStride
+	}
+	if res.PlacerImageSize == 0 {
+		res.PlacerImageSize = defaults.PlacerImageSize
+	}
+	return &res
+}
+
+// validate checks for settings which would make the
+// detector hang, crash, or silently skip work.
+func (c *DetectorConfig) validate() error {
+	if !(c.OverlapThreshold > 0 && c.OverlapThreshold <= 1) {
+		return fmt.Errorf("invalid overlap threshold: %f", c.OverlapThreshold)
+	}
+	if !(c.ScanStride > 1) || math.IsInf(c.ScanStride, 1) {
+		return fmt.Errorf("invalid scan stride: %f", c.ScanStride)
+	}
+	if !(c.ScanStartScale >= 0) || math.IsInf(c.ScanStartScale, 1) {
+		return fmt.Errorf("invalid scan start scale: %f", c.ScanStartScale)
+	}
+	if c.PlacerImageSize < 0 {
+		return fmt.Errorf("invalid placer image size: %d", c.PlacerImageSize)
+	}
+	if c.PlacerBatchSize < 0 {
+		return fmt.Errorf("invalid placer batch size: %d", c.PlacerBatchSize)
+	}
+	if c.Workers < 0 {
+		return fmt.Errorf("invalid worker count: %d", c.Workers)
+	}
+	return nil
+}
+
+// startScale computes the initial scanning scale, skipping
+// any scales which would only find faces smaller than the
+// minimum face size.
+func startScale(config *DetectorConfig, cascade *haar.Cascade) float64 {
+	scale := config.ScanStartScale
+	if config.MinFaceSize > 0 && cascade.WindowWidth > 0 {
+		minScale := float64(config.MinFaceSize) / float64(cascade.WindowWidth)
+		if minScale > scale {
+			scale = minScale
+		}
+	}
+	return scale
+}
+
+// scanCascade is like cascade.Scan, but it runs the scan
+// one scale at a time and stops between scales if ctx is
+// done.
+func scanCascade(ctx context.Context, cascade *haar.Cascade, img haar.IntegralImage,
+	startScale, stride float64) (haar.Matches, error) {
+	if cascade.WindowWidth <= 0 || cascade.WindowHeight <= 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return cascade.Scan(img, startScale, stride), nil
+	}
+	if startScale == 0 {
+		startScale = 1
+	}
+	maxScale := math.Min(float64(img.Width())/float64(cascade.WindowWidth),
+		float64(img.Height())/float64(cascade.WindowHeight))
+
+	// With this stride, the second scale of each call to
+	// Scan is too big for the image.
+	singleStride := 2 * maxScale / startScale
+
+	var res haar.Matches
+	for scale := startScale; scale <= maxScale; scale *= stride {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res = append(res, cascade.Scan(img, scale, singleStride)...)
+	}
+	return res, nil
+}
+
+// faceConfidence counts the raw cascade hits which are
+// centered inside of a merged face and are roughly the
+// same size as it.
+func faceConfidence(rawRects []image.Rectangle, face image.Rectangle) float64 {
+	var count int
+	for _, r := range rawRects {
+		center := r.Min.Add(r.Max).Div(2)
+		sizeRatio := float64(r.Dx()) / float64(face.Dx())
+		if center.In(face) && sizeRatio >= 0.5 && sizeRatio <= 2 {
+			count++
+		}
+	}
+	return float64(count)
 }
@@ -0,0 +1,50 @@
+package mustacher
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDetectorConfigDefaults(t *testing.T) {
+	d := &Detector{Config: &DetectorConfig{MaxFaces: 3}}
+	config := d.config()
+	defaults := DefaultDetectorConfig()
+	if config.OverlapThreshold != defaults.OverlapThreshold ||
+		config.ScanStride != defaults.ScanStride ||
+		config.PlacerImageSize != defaults.PlacerImageSize {
+		t.Errorf("zero fields were not filled in: %+v", config)
+	}
+	if config.MaxFaces != 3 || config.PlacerBatchSize != 0 {
+		t.Errorf("non-default fields were changed: %+v", config)
+	}
+	if err := config.validate(); err != nil {
+		t.Error(err)
+	}
+	if d.Config.ScanStride != 0 {
+		t.Error("config() modified the detector's Config")
+	}
+}
+
+func TestDetectorConfigValidate(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Modify func(c *DetectorConfig)
+	}{
+		{"SmallStride", func(c *DetectorConfig) { c.ScanStride = 1 }},
+		{"NaNStride", func(c *DetectorConfig) { c.ScanStride = math.NaN() }},
+		{"NegativeWorkers", func(c *DetectorConfig) { c.Workers = -1 }},
+		{"NegativeBatch", func(c *DetectorConfig) { c.PlacerBatchSize = -1 }},
+		{"NegativeImageSize", func(c *DetectorConfig) { c.PlacerImageSize = -28 }},
+		{"NegativeStartScale", func(c *DetectorConfig) { c.ScanStartScale = -1 }},
+		{"NegativeOverlap", func(c *DetectorConfig) { c.OverlapThreshold = -0.5 }},
+		{"LargeOverlap", func(c *DetectorConfig) { c.OverlapThreshold = 1.5 }},
+	}
+	for _, test := range tests {
+		config := DefaultDetectorConfig()
+		test.Modify(config)
+		d := &Detector{Config: config}
+		if err := d.config().validate(); err == nil {
+			t.Errorf("%s: expected an error", test.Name)
+		}
+	}
+}
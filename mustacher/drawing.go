@@ -5,54 +5,355 @@ package mustacher
 import (
 	"image"
 	"image/color"
+	"image/draw"
+	"math"
 
-	"github.com/llgcode/draw2d"
 	"github.com/llgcode/draw2d/draw2dimg"
 )
 
+// layerMargin is the padding around each mustache's
+// layer, relative to the mustache width, which leaves
+// room for effects like shadows.
+const layerMargin = 0.1
+
+// maxStrokeWidth limits the width of outlines, relative
+// to the mustache width.
+const maxStrokeWidth = 0.25
+
+// DrawOptions controls how mustaches are drawn.
+type DrawOptions struct {
+	// Style is used for matches that do not specify
+	// their own Style.
+	// If nil, DefaultStyle() is used.
+	Style Style
+
+	// Color, if non-nil, overrides the color of every
+	// mustache.
+	// Otherwise, each match's Color is used.
+	Color color.Color
+
+	// Opacity is the opacity of the mustaches, from 0
+	// to 1.
+	// A value of 0 is treated as 1.
+	Opacity float64
+
+	// StrokeWidth is the width, in pixels, of an outline
+	// drawn around each mustache.
+	// It is limited to a quarter of the mustache's width.
+	// If 0, no outline is drawn.
+	StrokeWidth float64
+
+	// StrokeColor is the color of the outline.
+	// If nil, black is used.
+	StrokeColor color.Color
+
+	// Scale multiplies the size of every mustache.
+	// A value of 0 is treated as 1.
+	Scale float64
+
+	// AngleOffset is added to the angle of every
+	// mustache, in radians.
+	AngleOffset float64
+
+	// Aliased disables anti-aliasing, producing hard
+	// edges.
+	Aliased bool
+
+	// Realistic enables hair texture, shading, feathered
+	// edges, and a drop shadow.
+	// If false, mustaches are drawn as flat cartoons.
+	Realistic bool
+
+	// PreserveColorModel makes DrawWithOptions produce an
+	// image of the same type as its input when possible,
+	// keeping things like palettes and grayscale.
+	// Otherwise, the output is an *image.RGBA.
+	PreserveColorModel bool
+
+	// Overrides maps matches to options for those
+	// matches.
+	// The non-zero fields of an override replace the
+	// corresponding fields of these options, so boolean
+	// options can be turned on but not off.
+	// The PreserveColorModel and Overrides fields of an
+	// override are ignored.
+	Overrides map[*Match]*DrawOptions
+}
+
 // Draw generates a new image by drawing a mustache
 // for every match in a list of matches.
+//
+// Matches without a Style are drawn with the default
+// style.
 func Draw(img image.Image, matches []*Match) image.Image {
-	newImage := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
-	ctx := draw2dimg.NewGraphicContext(newImage)
-	ctx.DrawImage(img)
-	for _, match := range matches {
-		ctx.Save()
-		ctx.Translate(match.X, match.Y)
-		ctx.Rotate(match.Angle)
-		drawMustache(ctx, match.Radius*2)
-		ctx.Restore()
+	return DrawWithOptions(img, matches, nil)
+}
+
+// DrawStyle is like Draw, but it uses the given style
+// for matches that do not specify their own Style.
+//
+// To draw an image asset instead of a vector mustache,
+// pass an *ImageStyle.
+func DrawStyle(img image.Image, matches []*Match, style Style) image.Image {
+	return DrawWithOptions(img, matches, &DrawOptions{Style: style})
+}
+
+// DrawColor is like Draw, but it draws every mustache in
+// the given color instead of the estimated hair color.
+func DrawColor(img image.Image, matches []*Match, c color.Color) image.Image {
+	return DrawWithOptions(img, matches, &DrawOptions{Color: c})
+}
+
+// DrawWithOptions is like Draw, but it allows the caller
+// to customize the mustaches.
+// If opts is nil, default options are used.
+//
+// The resulting image has the same bounds as img.
+func DrawWithOptions(img image.Image, matches []*Match, opts *DrawOptions) image.Image {
+	var newImage draw.Image
+	if opts != nil && opts.PreserveColorModel {
+		newImage = cloneImage(img)
+	} else {
+		newImage = image.NewRGBA(img.Bounds())
+		draw.Draw(newImage, img.Bounds(), img, img.Bounds().Min, draw.Src)
 	}
+	DrawInPlace(newImage, matches, opts)
 	return newImage
 }
 
-func drawMustache(ctx draw2d.GraphicContext, width float64) {
-	// We do not use ctx.Scale() for scaling because scaling
-	// up Bezier curves makes their vertices visible.
-	scale := width / 100
+// DrawInPlace draws mustaches directly onto an image.
+// The matches should be in the image's coordinate system.
+//
+// If opts is nil, default options are used.
+// The PreserveColorModel option has no effect, since the
+// image's color model is always kept.
+func DrawInPlace(img draw.Image, matches []*Match, opts *DrawOptions) {
+	if opts == nil {
+		opts = &DrawOptions{}
+	}
+	for _, match := range matches {
+		matchOpts := opts
+		if override, ok := opts.Overrides[match]; ok && override != nil {
+			matchOpts = opts.merge(override)
+		}
+		drawMatch(img, match, matchOpts)
+	}
+}
 
-	ctx.Save()
-	ctx.Translate(-50*scale, -15*scale)
+// merge copies the options and replaces fields with the
+// non-zero fields of an override.
+func (d *DrawOptions) merge(override *DrawOptions) *DrawOptions {
+	res := *d
+	if override.Style != nil {
+		res.Style = override.Style
+	}
+	if override.Color != nil {
+		res.Color = override.Color
+	}
+	if override.Opacity != 0 {
+		res.Opacity = override.Opacity
+	}
+	if override.StrokeWidth != 0 {
+		res.StrokeWidth = override.StrokeWidth
+	}
+	if override.StrokeColor != nil {
+		res.StrokeColor = override.StrokeColor
+	}
+	if override.Scale != 0 {
+		res.Scale = override.Scale
+	}
+	if override.AngleOffset != 0 {
+		res.AngleOffset = override.AngleOffset
+	}
+	res.Aliased = res.Aliased || override.Aliased
+	res.Realistic = res.Realistic || override.Realistic
+	return &res
+}
+
+// cloneImage copies an image into a new image of the
+// same type, or into an *image.RGBA if the type cannot
+// be drawn to.
+func cloneImage(img image.Image) draw.Image {
+	bounds := img.Bounds()
+	var res draw.Image
+	switch img := img.(type) {
+	case *image.Paletted:
+		palette := make(color.Palette, len(img.Palette))
+		copy(palette, img.Palette)
+		res = image.NewPaletted(bounds, palette)
+	case *image.Gray:
+		res = image.NewGray(bounds)
+	case *image.Gray16:
+		res = image.NewGray16(bounds)
+	case *image.NRGBA:
+		res = image.NewNRGBA(bounds)
+	case *image.NRGBA64:
+		res = image.NewNRGBA64(bounds)
+	case *image.RGBA64:
+		res = image.NewRGBA64(bounds)
+	case *image.CMYK:
+		res = image.NewCMYK(bounds)
+	default:
+		res = image.NewRGBA(bounds)
+	}
+	draw.Draw(res, bounds, img, bounds.Min, draw.Src)
+	return res
+}
+
+// drawMatch renders a mustache onto a transparent layer,
+// applies effects to the layer, and composites it onto
+// the destination.
+func drawMatch(dst draw.Image, m *Match, opts *DrawOptions) {
+	scale := opts.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	width := m.Radius * 2 * scale
+	if width < 1 || math.IsNaN(width) || math.IsNaN(m.X) || math.IsNaN(m.Y) {
+		return
+	}
+	angle := m.Angle + opts.AngleOffset
+	strokeWidth := math.Min(opts.StrokeWidth, width*maxStrokeWidth)
+
+	// The layer is clipped to the destination, plus enough
+	// padding for effects that spread the mustache out, so
+	// that huge mustaches do not need huge layers.
+	margin := math.Floor(width*layerMargin+strokeWidth) + 2
+	half := math.Ceil(width) + margin
+	x, y := math.Floor(m.X), math.Floor(m.Y)
+	bounds := dst.Bounds()
+	pad := math.Min(margin, float64(bounds.Dx()+bounds.Dy()))
+	layerRect := image.Rect(
+		int(math.Max(x-half, float64(bounds.Min.X)-pad)),
+		int(math.Max(y-half, float64(bounds.Min.Y)-pad)),
+		int(math.Min(x+half, float64(bounds.Max.X)+pad)),
+		int(math.Min(y+half, float64(bounds.Max.Y)+pad)),
+	)
+	if layerRect.Empty() {
+		return
+	}
+	layer := image.NewRGBA(image.Rect(0, 0, layerRect.Dx(), layerRect.Dy()))
+	origin := layerRect.Min
+	centerX := m.X - float64(origin.X)
+	centerY := m.Y - float64(origin.Y)
 
-	ctx.SetFillColor(color.Black)
-	ctx.BeginPath()
-	ctx.MoveTo(14*scale, 4*scale)
+	ctx := draw2dimg.NewGraphicContext(layer)
+	ctx.Translate(centerX, centerY)
+	ctx.Rotate(angle)
+	ctx.SetFillColor(matchColor(m, opts))
+	matchStyle(m, opts.Style).Draw(ctx, width)
+	if m.View != FrontView {
+		layer = profileLayer(layer, centerX, centerY, angle, m.View)
+	}
 
-	// "Bottom" section of mustache.
-	ctx.CubicCurveTo(12*scale, 1*scale, 0*scale, 1*scale, 0*scale, 13*scale)
-	ctx.CubicCurveTo(0*scale, 33*scale, 30*scale, 33*scale, 50*scale, 17*scale)
-	ctx.CubicCurveTo(70*scale, 33*scale, 100*scale, 33*scale, 100*scale, 13*scale)
-	ctx.CubicCurveTo(100*scale, 1*scale, 88*scale, 1*scale, 86*scale, 4*scale)
+	if opts.Realistic {
+		textureLayer(layer, centerX, centerY, width, angle)
+	}
+	if strokeWidth > 0 {
+		strokeColor := opts.StrokeColor
+		if strokeColor == nil {
+			strokeColor = color.Black
+		}
+		strokeLayer(layer, strokeWidth, strokeColor)
+	}
+	if opts.Realistic {
+		blurRGBA(layer, int(math.Ceil(width*realisticFeather)))
+	}
+	if opts.Aliased {
+		aliasLayer(layer)
+	}
 
-	// "Top" section of mustache.
-	ctx.CubicCurveTo(96*scale, 4*scale, 96*scale, 19*scale, 88*scale, 19*scale)
-	ctx.CubicCurveTo(83*scale, 19*scale, 68*scale, 1*scale, 60*scale, 1*scale)
-	ctx.CubicCurveTo(57*scale, 1*scale, 53*scale, 2*scale, 50*scale, 6*scale)
-	ctx.CubicCurveTo(47*scale, 2*scale, 43*scale, 1*scale, 40*scale, 1*scale)
-	ctx.CubicCurveTo(32*scale, 1*scale, 17*scale, 19*scale, 12*scale, 19*scale)
-	ctx.CubicCurveTo(4*scale, 19*scale, 4*scale, 4*scale, 14*scale, 4*scale)
+	opacity := opts.Opacity
+	if opacity == 0 {
+		opacity = 1
+	}
+	mask := image.NewUniform(color.Alpha16{A: uint16(math.Min(1, opacity) * 0xffff)})
 
-	ctx.Close()
-	ctx.Fill()
-	ctx.Restore()
+	if opts.Realistic {
+		shadow, offset := shadowLayer(layer, width, angle)
+		draw.DrawMask(dst, layer.Bounds().Add(origin.Add(offset)), shadow, image.ZP,
+			mask, image.ZP, draw.Over)
+	}
+	draw.DrawMask(dst, layer.Bounds().Add(origin), layer, image.ZP, mask, image.ZP,
+		draw.Over)
+}
+
+// strokeLayer outlines the shapes in a layer by placing
+// a dilated copy of the layer behind them.
+func strokeLayer(layer *image.RGBA, strokeWidth float64, c color.Color) {
+	r, g, b, a := c.RGBA()
+	radius := int(math.Ceil(strokeWidth))
+	var offsets []image.Point
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			if math.Hypot(float64(x), float64(y)) <= strokeWidth {
+				offsets = append(offsets, image.Pt(x, y))
+			}
+		}
+	}
+
+	bounds := layer.Bounds()
+	stroke := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var maxAlpha uint8
+			for _, offset := range offsets {
+				p := image.Pt(x, y).Add(offset)
+				if !p.In(bounds) {
+					continue
+				}
+				if alpha := layer.Pix[layer.PixOffset(p.X, p.Y)+3]; alpha > maxAlpha {
+					maxAlpha = alpha
+				}
+			}
+			if maxAlpha == 0 {
+				continue
+			}
+			scale := uint32(maxAlpha)
+			idx := stroke.PixOffset(x, y)
+			stroke.Pix[idx] = uint8((r >> 8) * scale / 0xff)
+			stroke.Pix[idx+1] = uint8((g >> 8) * scale / 0xff)
+			stroke.Pix[idx+2] = uint8((b >> 8) * scale / 0xff)
+			stroke.Pix[idx+3] = uint8((a >> 8) * scale / 0xff)
+		}
+	}
+	draw.Draw(stroke, bounds, layer, bounds.Min, draw.Over)
+	copy(layer.Pix, stroke.Pix)
+}
+
+// aliasLayer makes every pixel in a layer either fully
+// opaque or fully transparent.
+func aliasLayer(layer *image.RGBA) {
+	for i := 0; i < len(layer.Pix); i += 4 {
+		alpha := uint32(layer.Pix[i+3])
+		if alpha < 0x80 {
+			layer.Pix[i], layer.Pix[i+1], layer.Pix[i+2], layer.Pix[i+3] = 0, 0, 0, 0
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			layer.Pix[i+j] = uint8(uint32(layer.Pix[i+j]) * 0xff / alpha)
+		}
+		layer.Pix[i+3] = 0xff
+	}
+}
+
+func matchStyle(m *Match, defaultStyle Style) Style {
+	if m.Style != "" {
+		if s, ok := LookupStyle(m.Style); ok {
+			return s
+		}
+	}
+	if defaultStyle == nil {
+		return DefaultStyle()
+	}
+	return defaultStyle
+}
+
+func matchColor(m *Match, opts *DrawOptions) color.Color {
+	if opts.Color != nil {
+		return opts.Color
+	} else if m.Color != nil {
+		return m.Color
+	}
+	return color.Black
 }
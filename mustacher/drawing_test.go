@@ -0,0 +1,42 @@
+package mustacher
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestDrawHugeMatch(t *testing.T) {
+	// The layer should be clipped to the image rather than
+	// allocated at the full mustache size.
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	matches := []*Match{{X: 10, Y: 5, Radius: 1e7}}
+	opts := &DrawOptions{Scale: 1e4, StrokeWidth: 3, Realistic: true}
+	res := DrawWithOptions(img, matches, opts)
+	if res.Bounds() != img.Bounds() {
+		t.Errorf("unexpected bounds: %v", res.Bounds())
+	}
+}
+
+func TestDrawOptionsMerge(t *testing.T) {
+	base := &DrawOptions{
+		Style:       walrusStyle,
+		Color:       color.White,
+		Opacity:     0.5,
+		StrokeWidth: 2,
+		Scale:       1.5,
+		Realistic:   true,
+	}
+	override := &DrawOptions{Color: color.Black, Aliased: true}
+	actual := base.merge(override)
+	expected := *base
+	expected.Color = color.Black
+	expected.Aliased = true
+	if !reflect.DeepEqual(actual, &expected) {
+		t.Errorf("expected %+v but got %+v", &expected, actual)
+	}
+	if base.Color != color.White || base.Aliased {
+		t.Error("merge modified the base options")
+	}
+}
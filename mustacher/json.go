@@ -0,0 +1,119 @@
+package mustacher
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// matchJSON is the JSON encoding of a Match.
+type matchJSON struct {
+	X      float64
+	Y      float64
+	Radius float64
+	Angle  float64
+
+	Style string `json:",omitempty"`
+	Color string `json:",omitempty"`
+
+	Face         rectJSON
+	Confidence   float64
+	PlacerOutput []float64 `json:",omitempty"`
+	View         FaceView
+}
+
+type rectJSON struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+// MarshalJSON encodes the match with its Color as a
+// "#rrggbb" string, its Face as X, Y, Width, and Height,
+// and its View as a name like "front".
+func (m *Match) MarshalJSON() ([]byte, error) {
+	obj := matchJSON{
+		X:      m.X,
+		Y:      m.Y,
+		Radius: m.Radius,
+		Angle:  m.Angle,
+		Style:  m.Style,
+		Face: rectJSON{
+			X:      m.Face.Min.X,
+			Y:      m.Face.Min.Y,
+			Width:  m.Face.Dx(),
+			Height: m.Face.Dy(),
+		},
+		Confidence:   m.Confidence,
+		PlacerOutput: m.PlacerOutput,
+		View:         m.View,
+	}
+	if m.Color != nil {
+		c := color.NRGBAModel.Convert(m.Color).(color.NRGBA)
+		obj.Color = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return json.Marshal(&obj)
+}
+
+// UnmarshalJSON decodes a match encoded by MarshalJSON.
+func (m *Match) UnmarshalJSON(data []byte) error {
+	var obj matchJSON
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	face := obj.Face
+	*m = Match{
+		X:            obj.X,
+		Y:            obj.Y,
+		Radius:       obj.Radius,
+		Angle:        obj.Angle,
+		Style:        obj.Style,
+		Face:         image.Rect(face.X, face.Y, face.X+face.Width, face.Y+face.Height),
+		Confidence:   obj.Confidence,
+		PlacerOutput: obj.PlacerOutput,
+		View:         obj.View,
+	}
+	if obj.Color != "" {
+		c, err := ParseColor(obj.Color)
+		if err != nil {
+			return err
+		}
+		m.Color = c
+	}
+	return nil
+}
+
+var faceViewNames = map[FaceView]string{
+	FrontView:    "front",
+	LeftProfile:  "left",
+	RightProfile: "right",
+}
+
+// String returns "front", "left", or "right".
+func (f FaceView) String() string {
+	if name, ok := faceViewNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("FaceView(%d)", int(f))
+}
+
+// MarshalText encodes the view as its name.
+func (f FaceView) MarshalText() ([]byte, error) {
+	if _, ok := faceViewNames[f]; !ok {
+		return nil, fmt.Errorf("unknown face view: %d", int(f))
+	}
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText decodes a view name.
+func (f *FaceView) UnmarshalText(text []byte) error {
+	for view, name := range faceViewNames {
+		if name == string(text) {
+			*f = view
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown face view: %q", text)
+}
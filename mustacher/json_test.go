@@ -0,0 +1,88 @@
+package mustacher
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestMatchJSONRoundTrip(t *testing.T) {
+	matches := []*Match{
+		{
+			X:            10.5,
+			Y:            20,
+			Radius:       7,
+			Angle:        0.1,
+			Color:        color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff},
+			Face:         image.Rect(3, 4, 33, 44),
+			Confidence:   5,
+			PlacerOutput: []float64{0.5, 0.6, 0.2, 0.1},
+		},
+		{
+			X:      -3,
+			Y:      4,
+			Radius: 2,
+			Style:  "walrus",
+			Face:   image.Rect(-10, 0, 0, 10),
+			View:   LeftProfile,
+		},
+	}
+	data, err := json.Marshal(matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []*Match
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, matches) {
+		t.Errorf("expected %v but got %v", matches, decoded)
+	}
+}
+
+func TestMatchJSONFormat(t *testing.T) {
+	m := &Match{
+		X:      1,
+		Y:      2,
+		Radius: 3,
+		Color:  color.Gray{Y: 0xff},
+		Face:   image.Rect(5, 6, 15, 26),
+		View:   RightProfile,
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"X":          1.0,
+		"Y":          2.0,
+		"Radius":     3.0,
+		"Angle":      0.0,
+		"Color":      "#ffffff",
+		"Face":       map[string]interface{}{"X": 5.0, "Y": 6.0, "Width": 10.0, "Height": 20.0},
+		"Confidence": 0.0,
+		"View":       "right",
+	}
+	if !reflect.DeepEqual(obj, expected) {
+		t.Errorf("expected %v but got %v", expected, obj)
+	}
+}
+
+func TestMatchJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"View": "up"}`,
+		`{"Color": "blue"}`,
+		`{"X": "1"}`,
+	} {
+		var m Match
+		if err := json.Unmarshal([]byte(data), &m); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package mustacher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/unixpickle/mustachemash/imgtensor"
+)
+
+const exifOrientationTag = 0x0112
+
+// DecodeImage decodes an image and, if it is a JPEG
+// with an EXIF orientation tag, rotates or flips it so
+// that it is upright.
+//
+// The decoders for the image formats must be registered,
+// as for image.Decode.
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if format == "jpeg" {
+		img = orientImage(img, exifOrientation(data))
+	}
+	return img, format, nil
+}
+
+// LoadImage reads an image file with DecodeImage.
+func LoadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := DecodeImage(f)
+	return img, err
+}
+
+// exifOrientation finds the EXIF orientation of a JPEG
+// file.
+// If there is no valid orientation, 1 (upright) is
+// returned.
+func exifOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	data = data[2:]
+	for len(data) >= 4 && data[0] == 0xff {
+		marker := data[1]
+		if marker == 0xda || marker == 0xd9 {
+			// Image data starts at SOS, so EXIF must come
+			// before it.
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[2:]))
+		if size < 2 || len(data) < size+2 {
+			break
+		}
+		segment := data[4 : size+2]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		data = data[size+2:]
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation tag from the
+// first IFD of a TIFF structure.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 0 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// orientImage transforms an image with the given EXIF
+// orientation so that it is upright.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := imgtensor.Crop(img, img.Bounds())
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var srcX, srcY int
+			switch orientation {
+			case 2:
+				srcX, srcY = w-1-x, y
+			case 3:
+				srcX, srcY = w-1-x, h-1-y
+			case 4:
+				srcX, srcY = x, h-1-y
+			case 5:
+				srcX, srcY = y, x
+			case 6:
+				srcX, srcY = y, h-1-x
+			case 7:
+				srcX, srcY = w-1-y, h-1-x
+			case 8:
+				srcX, srcY = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4],
+				src.Pix[src.PixOffset(srcX, srcY):])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,164 @@
+package mustacher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestExifOrientation(t *testing.T) {
+	jpegData := encodeTestJPEG(t, image.NewGray(image.Rect(0, 0, 8, 8)))
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for orientation := 1; orientation <= 8; orientation++ {
+			data := insertEXIF(jpegData, exifSegment(order, orientation))
+			if actual := exifOrientation(data); actual != orientation {
+				t.Errorf("%s: expected orientation %d but got %d", order, orientation, actual)
+			}
+		}
+	}
+}
+
+func TestExifOrientationInvalid(t *testing.T) {
+	jpegData := encodeTestJPEG(t, image.NewGray(image.Rect(0, 0, 8, 8)))
+	valid := exifSegment(binary.BigEndian, 6)
+	tests := []struct {
+		Name string
+		Data []byte
+	}{
+		{"NoEXIF", jpegData},
+		{"NotJPEG", []byte("GIF89a")},
+		{"OutOfRange", insertEXIF(jpegData, exifSegment(binary.BigEndian, 9))},
+		{"BadByteOrder", insertEXIF(jpegData, replaceBytes(valid, 10, "XX"))},
+		{"BadMagic", insertEXIF(jpegData, replaceBytes(valid, 12, "\x00\x00"))},
+		{"Truncated", insertEXIF(jpegData, valid)[:30]},
+	}
+	for _, test := range tests {
+		if actual := exifOrientation(test.Data); actual != 1 {
+			t.Errorf("%s: expected orientation 1 but got %d", test.Name, actual)
+		}
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// Each corner of the source image gets its own color.
+	tl := color.RGBA{R: 1, A: 0xff}
+	tr := color.RGBA{R: 2, A: 0xff}
+	bl := color.RGBA{R: 3, A: 0xff}
+	br := color.RGBA{R: 4, A: 0xff}
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, tl)
+	src.Set(2, 0, tr)
+	src.Set(0, 1, bl)
+	src.Set(2, 1, br)
+
+	tests := []struct {
+		Orientation int
+		TopLeft     color.RGBA
+		TopRight    color.RGBA
+	}{
+		{1, tl, tr},
+		{2, tr, tl},
+		{3, br, bl},
+		{4, bl, br},
+		{5, tl, bl},
+		{6, bl, tl},
+		{7, br, tr},
+		{8, tr, br},
+	}
+	for _, test := range tests {
+		img := orientImage(src, test.Orientation)
+		bounds := img.Bounds()
+		expectedSize := image.Pt(3, 2)
+		if test.Orientation >= 5 {
+			expectedSize = image.Pt(2, 3)
+		}
+		if bounds.Size() != expectedSize {
+			t.Errorf("orientation %d: expected size %v but got %v", test.Orientation,
+				expectedSize, bounds.Size())
+			continue
+		}
+		if actual := img.At(bounds.Min.X, bounds.Min.Y); actual != test.TopLeft {
+			t.Errorf("orientation %d: expected top left %v but got %v", test.Orientation,
+				test.TopLeft, actual)
+		}
+		if actual := img.At(bounds.Max.X-1, bounds.Min.Y); actual != test.TopRight {
+			t.Errorf("orientation %d: expected top right %v but got %v", test.Orientation,
+				test.TopRight, actual)
+		}
+	}
+}
+
+func TestDecodeImageOrientation(t *testing.T) {
+	jpegData := encodeTestJPEG(t, image.NewGray(image.Rect(0, 0, 16, 8)))
+	for orientation := 1; orientation <= 8; orientation++ {
+		data := insertEXIF(jpegData, exifSegment(binary.LittleEndian, orientation))
+		img, format, err := DecodeImage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("orientation %d: %s", orientation, err)
+		}
+		if format != "jpeg" {
+			t.Errorf("orientation %d: unexpected format %s", orientation, format)
+		}
+		expectedSize := image.Pt(16, 8)
+		if orientation >= 5 {
+			expectedSize = image.Pt(8, 16)
+		}
+		if size := img.Bounds().Size(); size != expectedSize {
+			t.Errorf("orientation %d: expected size %v but got %v", orientation,
+				expectedSize, size)
+		}
+	}
+}
+
+func encodeTestJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exifSegment creates an APP1 segment whose first IFD
+// has an unrelated tag followed by an orientation tag.
+func exifSegment(order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 8+2+12*2+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 2)
+
+	// ResolutionUnit, then Orientation, both of type SHORT.
+	for i, tag := range [][2]uint16{{0x0128, 2}, {exifOrientationTag, uint16(orientation)}} {
+		entry := tiff[10+i*12:]
+		order.PutUint16(entry, tag[0])
+		order.PutUint16(entry[2:], 3)
+		order.PutUint32(entry[4:], 1)
+		order.PutUint16(entry[8:], tag[1])
+	}
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// insertEXIF inserts a segment right after the SOI
+// marker of a JPEG file.
+func insertEXIF(jpegData, segment []byte) []byte {
+	res := append([]byte{}, jpegData[:2]...)
+	res = append(res, segment...)
+	return append(res, jpegData[2:]...)
+}
+
+func replaceBytes(data []byte, offset int, s string) []byte {
+	res := append([]byte{}, data...)
+	copy(res[offset:], s)
+	return res
+}
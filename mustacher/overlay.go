@@ -0,0 +1,56 @@
+package mustacher
+
+import (
+	"image"
+	_ "image/png"
+	"math"
+
+	"github.com/llgcode/draw2d"
+	"github.com/nfnt/resize"
+)
+
+// An ImageStyle is a Style which composites a bitmap,
+// such as a photographed mustache with a transparent
+// background, at every match.
+//
+// The center of the image is placed at the center of
+// the match, and the image is scaled so that its width
+// matches the mustache's width.
+// Transparent parts of the image are alpha blended with
+// the destination.
+type ImageStyle struct {
+	Image image.Image
+}
+
+// LoadImageStyle reads an image file and creates an
+// ImageStyle from it.
+func LoadImageStyle(path string) (*ImageStyle, error) {
+	img, err := LoadImage(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageStyle{Image: img}, nil
+}
+
+// Draw draws the image, using the context's transform
+// to rotate it.
+func (i *ImageStyle) Draw(ctx draw2d.GraphicContext, width float64) {
+	bounds := i.Image.Bounds()
+	if bounds.Empty() || width < 1 {
+		return
+	}
+	height := width * float64(bounds.Dy()) / float64(bounds.Dx())
+
+	// The context resamples bilinearly, which aliases when
+	// shrinking an image by a large factor, so we do most
+	// of the scaling with a Lanczos filter first.
+	scaled := resize.Resize(uint(math.Ceil(width)), uint(math.Ceil(height)),
+		i.Image, resize.Lanczos3)
+	scaledBounds := scaled.Bounds()
+
+	ctx.Save()
+	ctx.Translate(-width/2, -height/2)
+	ctx.Scale(width/float64(scaledBounds.Dx()), height/float64(scaledBounds.Dy()))
+	ctx.DrawImage(scaled)
+	ctx.Restore()
+}
@@ -0,0 +1,183 @@
+package mustacher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+
+	"github.com/unixpickle/haar"
+	"github.com/unixpickle/mustachemash/imgtensor"
+)
+
+// These constants describe where a mustache goes on a
+// right-facing profile face, relative to the face's box.
+const (
+	profileMustacheX      = 0.72
+	profileMustacheY      = 0.7
+	profileMustacheRadius = 0.2
+)
+
+// profileFarScale is how much the far half of a mustache
+// is squeezed when it is drawn from the side.
+const profileFarScale = 0.3
+
+// A FaceView indicates which way a face is turned.
+type FaceView int
+
+const (
+	// FrontView is a face looking at the camera.
+	FrontView FaceView = iota
+
+	// LeftProfile is a face turned to look toward the
+	// left side of the image.
+	LeftProfile
+
+	// RightProfile is a face turned to look toward the
+	// right side of the image.
+	RightProfile
+)
+
+// LoadProfiles loads a profile face cascade from the
+// filesystem and stores it in d.Profiles.
+func (d *Detector) LoadProfiles(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cascade *haar.Cascade
+	if err := json.Unmarshal(data, &cascade); err != nil {
+		return fmt.Errorf("deserialize profiles cascade: %s", err)
+	}
+	d.Profiles = cascade
+	return nil
+}
+
+// profileMatches finds profile faces in an image and
+// places mustaches on them.
+// Faces which overlap the frontal faces are ignored.
+//
+// The raw cascade hits are returned as well, for
+// debugging.
+func (d *Detector) profileMatches(ctx context.Context, img image.Image, config *DetectorConfig,
+	frontal []image.Rectangle) ([]*Match, []image.Rectangle, error) {
+	bounds := img.Bounds()
+	mirrored := mirrorImage(img)
+
+	var scan struct {
+		Right haar.Matches
+		Left  haar.Matches
+	}
+	startScale := startScale(config, d.Profiles)
+	for _, view := range []FaceView{RightProfile, LeftProfile} {
+		viewImg, dest := img, &scan.Right
+		if view == LeftProfile {
+			viewImg, dest = mirrored, &scan.Left
+		}
+		dualImage := haar.NewDualImage(haar.ImageIntegralImage(viewImg))
+		var err error
+		*dest, err = scanCascade(ctx, d.Profiles, dualImage, startScale, config.ScanStride)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var rawRects []image.Rectangle
+	var matches []*Match
+	for _, view := range []FaceView{RightProfile, LeftProfile} {
+		raw := scan.Right
+		if view == LeftProfile {
+			raw = scan.Left
+		}
+		toRect := func(m *haar.Match) image.Rectangle {
+			if view == LeftProfile {
+				return image.Rect(bounds.Dx()-(m.X+m.Width), m.Y, bounds.Dx()-m.X,
+					m.Y+m.Height).Add(bounds.Min)
+			}
+			return image.Rect(m.X, m.Y, m.X+m.Width, m.Y+m.Height).Add(bounds.Min)
+		}
+		var viewRects []image.Rectangle
+		for _, m := range raw {
+			viewRects = append(viewRects, toRect(m))
+		}
+		rawRects = append(rawRects, viewRects...)
+
+	FaceLoop:
+		for _, m := range raw.JoinOverlaps(config.OverlapThreshold) {
+			if (config.MinFaceSize > 0 && m.Width < config.MinFaceSize) ||
+				(config.MaxFaceSize > 0 && m.Width > config.MaxFaceSize) {
+				continue
+			}
+			faceRect := toRect(m)
+			center := faceRect.Min.Add(faceRect.Max).Div(2)
+			for _, f := range frontal {
+				if center.In(f) {
+					continue FaceLoop
+				}
+			}
+			w, h := float64(faceRect.Dx()), float64(faceRect.Dy())
+			x := profileMustacheX
+			if view == LeftProfile {
+				x = 1 - x
+			}
+			matches = append(matches, &Match{
+				X:          float64(faceRect.Min.X) + x*w,
+				Y:          float64(faceRect.Min.Y) + profileMustacheY*h,
+				Radius:     profileMustacheRadius * w,
+				Color:      estimateHairColor(img, faceRect),
+				Face:       faceRect,
+				Confidence: faceConfidence(viewRects, faceRect),
+				View:       view,
+			})
+		}
+	}
+	return matches, rawRects, nil
+}
+
+// mirrorImage flips an image horizontally.
+// The resulting image's bounds start at (0, 0).
+func mirrorImage(img image.Image) *image.RGBA {
+	src := imgtensor.Crop(img, img.Bounds())
+	res := image.NewRGBA(src.Bounds())
+	width := src.Bounds().Dx()
+	for y := 0; y < src.Bounds().Dy(); y++ {
+		for x := 0; x < width; x++ {
+			srcIdx := src.PixOffset(width-(x+1), y)
+			copy(res.Pix[res.PixOffset(x, y):], src.Pix[srcIdx:srcIdx+4])
+		}
+	}
+	return res
+}
+
+// profileLayer redraws a mustache on a layer as it would
+// look from the side, squeezing the half of the mustache
+// which is farther from the camera.
+func profileLayer(layer *image.RGBA, centerX, centerY, angle float64,
+	view FaceView) *image.RGBA {
+	// The far half is on the side that the face looks
+	// toward.
+	farSign := 1.0
+	if view == LeftProfile {
+		farSign = -1
+	}
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	res := image.NewRGBA(layer.Bounds())
+	bounds := layer.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dx := float64(x) + 0.5 - centerX
+			dy := float64(y) + 0.5 - centerY
+			u := dx*cos + dy*sin
+			v := -dx*sin + dy*cos
+			if u*farSign > 0 {
+				u /= profileFarScale
+			}
+			srcX := centerX + u*cos - v*sin - 0.5
+			srcY := centerY + u*sin + v*cos - 0.5
+			sampleBilinear(layer, srcX, srcY, res.Pix[res.PixOffset(x, y):])
+		}
+	}
+	return res
+}
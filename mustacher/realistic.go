@@ -0,0 +1,151 @@
+package mustacher
+
+import (
+	"image"
+	"math"
+)
+
+// These constants are relative to the mustache width.
+const (
+	realisticFeather      = 0.008
+	realisticShadowOffset = 0.025
+	realisticShadowBlur   = 0.03
+	realisticStrandCount  = 90
+)
+
+const (
+	realisticShadowOpacity = 0.35
+	realisticStrandAmount  = 0.25
+	realisticGradient      = 0.15
+)
+
+// textureLayer adds hair strands and a vertical gradient
+// to a mustache on a transparent layer.
+func textureLayer(layer *image.RGBA, centerX, centerY, width, angle float64) {
+	bounds := layer.Bounds()
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			idx := layer.PixOffset(x, y)
+			alpha := float64(layer.Pix[idx+3])
+			if alpha == 0 {
+				continue
+			}
+
+			// Coordinates relative to the mustache, where the
+			// mustache spans u=-0.5 to u=0.5.
+			dx := float64(x) + 0.5 - centerX
+			dy := float64(y) + 0.5 - centerY
+			u := (dx*cos + dy*sin) / width
+			v := (-dx*sin + dy*cos) / width
+
+			// Hairs grow downward and outward from the lip.
+			strand := math.Abs(u) - 0.6*v
+			amount := realisticStrandAmount * (0.7*valueNoise(strand*realisticStrandCount) +
+				0.3*valueNoise(strand*realisticStrandCount*2.7+17))
+			amount += realisticGradient * math.Max(-1, math.Min(1, v*8))
+
+			for i := 0; i < 3; i++ {
+				val := float64(layer.Pix[idx+i])
+				if amount > 0 {
+					val += (alpha - val) * amount
+				} else {
+					val *= 1 + amount
+				}
+				layer.Pix[idx+i] = uint8(math.Max(0, math.Min(alpha, val)))
+			}
+		}
+	}
+}
+
+// shadowLayer creates a soft drop shadow for a layer.
+// The shadow falls "down" relative to the mustache, onto
+// the upper lip.
+//
+// The returned point is the offset of the shadow from
+// the layer.
+func shadowLayer(layer *image.RGBA, width, angle float64) (*image.RGBA, image.Point) {
+	shadow := image.NewRGBA(layer.Bounds())
+	for i := 3; i < len(layer.Pix); i += 4 {
+		shadow.Pix[i] = uint8(float64(layer.Pix[i]) * realisticShadowOpacity)
+	}
+	blurRGBA(shadow, int(math.Ceil(width*realisticShadowBlur)))
+
+	distance := width * realisticShadowOffset
+	offset := image.Pt(
+		int(math.Floor(-math.Sin(angle)*distance+0.5)),
+		int(math.Floor(math.Cos(angle)*distance+0.5)),
+	)
+	return shadow, offset
+}
+
+// blurRGBA applies a box blur to an image in place.
+// Since the image is premultiplied, this blurs alpha
+// correctly.
+//
+// The radius is limited to the size of the image.
+func blurRGBA(img *image.RGBA, radius int) {
+	if radius < 1 {
+		return
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if radius > width+height {
+		radius = width + height
+	}
+	buffer := make([]float64, len(img.Pix))
+	for i, x := range img.Pix {
+		buffer[i] = float64(x)
+	}
+	temp := make([]float64, len(buffer))
+	boxBlurPass(buffer, temp, width, height, radius, 4, img.Stride)
+	boxBlurPass(temp, buffer, height, width, radius, img.Stride, 4)
+	for i, x := range buffer {
+		img.Pix[i] = uint8(math.Max(0, math.Min(255, x+0.5)))
+	}
+}
+
+// boxBlurPass blurs along one axis of a pixel buffer.
+// The step is the distance between neighboring pixels
+// along the blurred axis, and lineStep is the distance
+// between neighboring lines.
+func boxBlurPass(src, dst []float64, length, lines, radius, step, lineStep int) {
+	scale := 1 / float64(2*radius+1)
+	for line := 0; line < lines; line++ {
+		base := line * lineStep
+		for channel := 0; channel < 4; channel++ {
+			var sum float64
+			for i := -radius; i <= radius; i++ {
+				if i >= 0 && i < length {
+					sum += src[base+i*step+channel]
+				}
+			}
+			for i := 0; i < length; i++ {
+				dst[base+i*step+channel] = sum * scale
+				if out := i - radius; out >= 0 {
+					sum -= src[base+out*step+channel]
+				}
+				if in := i + radius + 1; in < length {
+					sum += src[base+in*step+channel]
+				}
+			}
+		}
+	}
+}
+
+// valueNoise is smooth 1D noise in the range [-1, 1].
+func valueNoise(x float64) float64 {
+	i := math.Floor(x)
+	frac := x - i
+	frac = frac * frac * (3 - 2*frac)
+	a := hashNoise(int64(i))
+	b := hashNoise(int64(i) + 1)
+	return a + (b-a)*frac
+}
+
+func hashNoise(i int64) float64 {
+	h := uint64(i) * 0x9e3779b97f4a7c15
+	h ^= h >> 29
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 32
+	return float64(h&0xffff)/0xffff*2 - 1
+}
@@ -0,0 +1,118 @@
+package mustacher
+
+import (
+	"image"
+	"math"
+
+	"github.com/unixpickle/mustachemash/imgtensor"
+)
+
+// RotationScanAngles returns scan angles for
+// DetectorConfig.ScanAngles which cover moderately tilted
+// heads and sideways or upside-down photos.
+func RotationScanAngles() []float64 {
+	deg := math.Pi / 180
+	return []float64{-30 * deg, -15 * deg, 15 * deg, 30 * deg, 90 * deg, 180 * deg, 270 * deg}
+}
+
+// An imageRotation maps between an image and a rotated
+// copy of it.
+//
+// A face which is upright in the rotated copy is tilted
+// by Angle in the original image.
+type imageRotation struct {
+	Angle float64
+
+	// Bounds is the original image's bounds.
+	Bounds image.Rectangle
+
+	// Size is the size of the rotated copy, whose bounds
+	// start at (0, 0).
+	Size image.Point
+}
+
+func newImageRotation(bounds image.Rectangle, angle float64) *imageRotation {
+	cos, sin := math.Abs(math.Cos(angle)), math.Abs(math.Sin(angle))
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	return &imageRotation{
+		Angle:  angle,
+		Bounds: bounds,
+		Size: image.Pt(
+			int(math.Ceil(w*cos+h*sin-1e-6)),
+			int(math.Ceil(w*sin+h*cos-1e-6)),
+		),
+	}
+}
+
+// Rotate creates the rotated copy of an image.
+// Areas outside of the original image are transparent.
+func (r *imageRotation) Rotate(img image.Image) *image.RGBA {
+	src := imgtensor.Crop(img, r.Bounds)
+	res := image.NewRGBA(image.Rectangle{Max: r.Size})
+	for y := 0; y < r.Size.Y; y++ {
+		for x := 0; x < r.Size.X; x++ {
+			srcX, srcY := r.ToOriginal(float64(x)+0.5, float64(y)+0.5)
+			srcX -= float64(r.Bounds.Min.X) + 0.5
+			srcY -= float64(r.Bounds.Min.Y) + 0.5
+			sampleBilinear(src, srcX, srcY, res.Pix[res.PixOffset(x, y):])
+		}
+	}
+	return res
+}
+
+// ToOriginal maps a point from the rotated copy to the
+// original image.
+func (r *imageRotation) ToOriginal(x, y float64) (float64, float64) {
+	cos, sin := math.Cos(r.Angle), math.Sin(r.Angle)
+	x -= float64(r.Size.X) / 2
+	y -= float64(r.Size.Y) / 2
+	centerX := float64(r.Bounds.Min.X+r.Bounds.Max.X) / 2
+	centerY := float64(r.Bounds.Min.Y+r.Bounds.Max.Y) / 2
+	return centerX + x*cos - y*sin, centerY + x*sin + y*cos
+}
+
+// RectToOriginal maps a rectangle from the rotated copy
+// to the bounding box of the corresponding area in the
+// original image.
+func (r *imageRotation) RectToOriginal(rect image.Rectangle) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range []image.Point{rect.Min, rect.Max, {rect.Min.X, rect.Max.Y},
+		{rect.Max.X, rect.Min.Y}} {
+		x, y := r.ToOriginal(float64(p.X), float64(p.Y))
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+	}
+	return image.Rect(int(math.Floor(minX+1e-6)), int(math.Floor(minY+1e-6)),
+		int(math.Ceil(maxX-1e-6)), int(math.Ceil(maxY-1e-6)))
+}
+
+// sampleBilinear writes the premultiplied RGBA color of
+// img at a point to dst, where pixel centers are at
+// integer coordinates.
+// Points outside of img are transparent.
+func sampleBilinear(img *image.RGBA, x, y float64, dst []uint8) {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fracX, fracY := x-float64(x0), y-float64(y0)
+	var sum [4]float64
+	for _, corner := range []struct {
+		X, Y   int
+		Weight float64
+	}{
+		{x0, y0, (1 - fracX) * (1 - fracY)},
+		{x0 + 1, y0, fracX * (1 - fracY)},
+		{x0, y0 + 1, (1 - fracX) * fracY},
+		{x0 + 1, y0 + 1, fracX * fracY},
+	} {
+		if corner.Weight == 0 || !image.Pt(corner.X, corner.Y).In(img.Bounds()) {
+			continue
+		}
+		idx := img.PixOffset(corner.X, corner.Y)
+		for i := range sum {
+			sum[i] += corner.Weight * float64(img.Pix[idx+i])
+		}
+	}
+	for i, s := range sum {
+		dst[i] = uint8(math.Min(255, s+0.5))
+	}
+}
@@ -0,0 +1,265 @@
+package mustacher
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/llgcode/draw2d"
+)
+
+// DefaultStyleName is the name of the style used when
+// no other style is specified.
+const DefaultStyleName = "classic"
+
+// A Style draws a particular kind of mustache.
+type Style interface {
+	// Draw draws a mustache of the given width.
+	// The mustache should be centered at the origin of
+	// the context, with its width spanning the x-axis.
+	//
+	// Vector styles should fill their shapes with the
+	// context's current fill color.
+	Draw(ctx draw2d.GraphicContext, width float64)
+}
+
+// A StyleFunc is a Style implemented by a function.
+type StyleFunc func(ctx draw2d.GraphicContext, width float64)
+
+// Draw calls f(ctx, width).
+func (f StyleFunc) Draw(ctx draw2d.GraphicContext, width float64) {
+	f(ctx, width)
+}
+
+var (
+	stylesLock sync.RWMutex
+	styles     = map[string]Style{
+		DefaultStyleName: classicStyle,
+		"handlebar":      handlebarStyle,
+		"chevron":        chevronStyle,
+		"walrus":         walrusStyle,
+		"pencil":         pencilStyle,
+		"fumanchu":       fuManchuStyle,
+		"imperial":       imperialStyle,
+	}
+)
+
+// RegisterStyle adds a style to the registry of named
+// styles, replacing any existing style with that name.
+func RegisterStyle(name string, s Style) {
+	stylesLock.Lock()
+	defer stylesLock.Unlock()
+	styles[name] = s
+}
+
+// LookupStyle finds a registered style by name.
+func LookupStyle(name string) (s Style, ok bool) {
+	stylesLock.RLock()
+	defer stylesLock.RUnlock()
+	s, ok = styles[name]
+	return
+}
+
+// StyleNames returns the sorted names of all the
+// registered styles.
+func StyleNames() []string {
+	stylesLock.RLock()
+	defer stylesLock.RUnlock()
+	var res []string
+	for name := range styles {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// DefaultStyle returns the style registered under
+// DefaultStyleName.
+func DefaultStyle() Style {
+	s, _ := LookupStyle(DefaultStyleName)
+	return s
+}
+
+// ParseStyle finds a registered style by name, or loads
+// a style from a file.
+// Files ending in .svg are loaded with LoadSVGStyle, and
+// image files are loaded with LoadImageStyle (the image
+// format's decoder must be registered).
+func ParseStyle(name string) (Style, error) {
+	if style, ok := LookupStyle(name); ok {
+		return style, nil
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".svg":
+		return LoadSVGStyle(name)
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+		return LoadImageStyle(name)
+	}
+	return nil, fmt.Errorf("unknown style %q (available: %s)", name,
+		strings.Join(StyleNames(), ", "))
+}
+
+// A curveStyle is a symmetric mustache outline made of
+// cubic Bezier curves.
+//
+// Coordinates are in a box which is 100 units wide.
+// Only the left half of the outline is specified: it
+// starts on the center line (x=50) at the top of the
+// mustache, goes around the left side, and ends on the
+// center line at the bottom of the mustache.
+// The right half is obtained by mirroring.
+type curveStyle struct {
+	// CenterY is the y coordinate which is placed at
+	// the center of a match.
+	CenterY float64
+
+	Start  [2]float64
+	Curves [][6]float64
+}
+
+func (c *curveStyle) Draw(ctx draw2d.GraphicContext, width float64) {
+	// Like SVGStyle, we scale coordinates manually rather
+	// than using ctx.Scale().
+	scale := width / 100
+
+	ctx.Save()
+	ctx.Translate(-50*scale, -c.CenterY*scale)
+
+	ctx.BeginPath()
+	ctx.MoveTo(c.Start[0]*scale, c.Start[1]*scale)
+	for _, curve := range c.Curves {
+		ctx.CubicCurveTo(curve[0]*scale, curve[1]*scale, curve[2]*scale,
+			curve[3]*scale, curve[4]*scale, curve[5]*scale)
+	}
+	for i := len(c.Curves) - 1; i >= 0; i-- {
+		curve := c.Curves[i]
+		var end [2]float64
+		if i == 0 {
+			end = c.Start
+		} else {
+			prev := c.Curves[i-1]
+			end = [2]float64{prev[4], prev[5]}
+		}
+		ctx.CubicCurveTo((100-curve[2])*scale, curve[3]*scale,
+			(100-curve[0])*scale, curve[1]*scale,
+			(100-end[0])*scale, end[1]*scale)
+	}
+	ctx.Close()
+	ctx.Fill()
+	ctx.Restore()
+}
+
+// classicSVG is a copy of placement_maker/mustache.svg,
+// so that the renderer draws the same mustache that the
+// placement tool shows.
+const classicSVG = `<?xml version="1.0" encoding="utf-8" ?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" viewBox="0 0 100 30">
+  <!-- Line 1: bottom left part of mustache.
+       Line 2: bottom right part of mustache.
+       Line 3: top right part of mustache.
+       Line 4: top left part of mustache.
+  -->
+  <path
+    d="M14,4 c-2,-3 -14,-3 -14,9 c0,20 30,20 50,4
+       c20,16 50,16 50,-4 c0,-12 -12,-12 -14,-9
+       c10,0 10,15 2,15 c-5,0 -20,-18 -28,-18 c-3,0 -7,1 -10,5
+       c-3,-4 -7,-5 -10,-5 c-8,0 -23,18 -28,18 c-8,0 -8,-15 2,-15 z" />
+</svg>
+`
+
+var classicStyle = mustParseSVGStyle(classicSVG)
+
+func mustParseSVGStyle(data string) *SVGStyle {
+	s, err := ParseSVGStyle([]byte(data))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+var handlebarStyle = &curveStyle{
+	CenterY: 16,
+	Start:   [2]float64{50, 10},
+	Curves: [][6]float64{
+		// Top edge, running out to the inside of the curl.
+		{40, 6, 28, 12, 18, 14},
+		{12, 16, 10, 10, 10, 4},
+
+		// Tip of the curl.
+		{10, 1, 6, 1, 5, 4},
+
+		// Outside of the curl and bottom edge.
+		{4, 12, 10, 24, 22, 24},
+		{36, 24, 44, 22, 50, 22},
+	},
+}
+
+var chevronStyle = &curveStyle{
+	CenterY: 12,
+	Start:   [2]float64{50, 2},
+	Curves: [][6]float64{
+		{34, 0, 14, 4, 2, 26},
+		{16, 26, 34, 20, 50, 18},
+	},
+}
+
+var walrusStyle = &curveStyle{
+	CenterY: 18,
+	Start:   [2]float64{50, 0},
+	Curves: [][6]float64{
+		// Bushy top and drooping sides.
+		{30, -2, 8, 2, 2, 20},
+		{0, 30, 2, 40, 6, 44},
+
+		// Ragged fringe covering the mouth.
+		{14, 40, 18, 44, 24, 40},
+		{30, 44, 36, 40, 42, 42},
+		{46, 38, 48, 36, 50, 36},
+	},
+}
+
+var pencilStyle = &curveStyle{
+	CenterY: 10,
+	Start:   [2]float64{50, 8},
+	Curves: [][6]float64{
+		{36, 6, 14, 6, 4, 10},
+		{2, 11, 3, 13, 6, 13},
+		{18, 14, 36, 13, 50, 12},
+	},
+}
+
+var fuManchuStyle = &curveStyle{
+	CenterY: 6,
+	Start:   [2]float64{50, 4},
+	Curves: [][6]float64{
+		// Upper lip out to the corner of the mouth.
+		{38, 3, 28, 4, 22, 8},
+
+		// Long strand hanging past the chin.
+		{18, 14, 16, 50, 10, 88},
+		{9, 93, 14, 95, 15, 90},
+		{20, 55, 22, 22, 27, 14},
+
+		// Bottom edge back to the center.
+		{34, 9, 42, 8, 50, 8},
+	},
+}
+
+var imperialStyle = &curveStyle{
+	CenterY: 16,
+	Start:   [2]float64{50, 10},
+	Curves: [][6]float64{
+		// Top edge, running out to the inside of the curl.
+		{40, 4, 28, 6, 20, 12},
+		{14, 16, 8, 12, 8, 4},
+
+		// Tip of the curl.
+		{8, -2, 2, -2, 0, 4},
+
+		// Outside of the curl and bottom edge.
+		{-2, 14, 6, 26, 20, 26},
+		{34, 26, 44, 22, 50, 20},
+	},
+}
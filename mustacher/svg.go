@@ -0,0 +1,508 @@
+package mustacher
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/llgcode/draw2d"
+)
+
+// An SVGSegment is one command in an SVGPath.
+//
+// The Command is one of 'M', 'L', 'Q', 'C', or 'Z'.
+// Args stores the absolute coordinates of the control
+// points and end point, as x, y pairs.
+type SVGSegment struct {
+	Command byte
+	Args    []float64
+}
+
+// An SVGPath is a normalized list of path commands.
+type SVGPath []SVGSegment
+
+// ParseSVGPath parses the "d" attribute of an SVG path.
+//
+// All of the commands from the SVG specification are
+// supported except for elliptical arcs.
+// Relative, horizontal, vertical, and shorthand commands
+// are converted to their absolute counterparts.
+func ParseSVGPath(d string) (SVGPath, error) {
+	p := &svgPathParser{data: d}
+	var res SVGPath
+	var cmd byte
+	var curX, curY, startX, startY float64
+	var lastCtrlX, lastCtrlY float64
+	var lastCmd byte
+	for {
+		p.skipSeparators()
+		if p.done() {
+			break
+		}
+		if c := p.data[p.idx]; isSVGCommand(c) {
+			cmd = c
+			p.idx++
+		} else if cmd == 0 {
+			return nil, fmt.Errorf("svg path: expected command at offset %d", p.idx)
+		} else if cmd == 'Z' || cmd == 'z' {
+			return nil, fmt.Errorf("svg path: unexpected argument at offset %d", p.idx)
+		}
+
+		relative := cmd >= 'a' && cmd <= 'z'
+		var offX, offY float64
+		if relative {
+			offX, offY = curX, curY
+		}
+
+		var seg SVGSegment
+		switch cmd {
+		case 'Z', 'z':
+			seg = SVGSegment{Command: 'Z'}
+			curX, curY = startX, startY
+		case 'M', 'm', 'L', 'l', 'T', 't':
+			args, err := p.numbers(2)
+			if err != nil {
+				return nil, err
+			}
+			x, y := args[0]+offX, args[1]+offY
+			switch cmd {
+			case 'M', 'm':
+				seg = SVGSegment{Command: 'M', Args: []float64{x, y}}
+				startX, startY = x, y
+
+				// Subsequent coordinate pairs are implicit
+				// lineto commands.
+				if relative {
+					cmd = 'l'
+				} else {
+					cmd = 'L'
+				}
+			case 'L', 'l':
+				seg = SVGSegment{Command: 'L', Args: []float64{x, y}}
+			default:
+				cx, cy := curX, curY
+				if lastCmd == 'Q' {
+					cx, cy = 2*curX-lastCtrlX, 2*curY-lastCtrlY
+				}
+				seg = SVGSegment{Command: 'Q', Args: []float64{cx, cy, x, y}}
+			}
+			curX, curY = x, y
+		case 'H', 'h', 'V', 'v':
+			args, err := p.numbers(1)
+			if err != nil {
+				return nil, err
+			}
+			if cmd == 'H' || cmd == 'h' {
+				curX = args[0] + offX
+			} else {
+				curY = args[0] + offY
+			}
+			seg = SVGSegment{Command: 'L', Args: []float64{curX, curY}}
+		case 'C', 'c', 'S', 's', 'Q', 'q':
+			count := 6
+			if cmd != 'C' && cmd != 'c' {
+				count = 4
+			}
+			args, err := p.numbers(count)
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < len(args); i += 2 {
+				args[i] += offX
+				args[i+1] += offY
+			}
+			switch cmd {
+			case 'Q', 'q':
+				seg = SVGSegment{Command: 'Q', Args: args}
+			case 'S', 's':
+				cx, cy := curX, curY
+				if lastCmd == 'C' {
+					cx, cy = 2*curX-lastCtrlX, 2*curY-lastCtrlY
+				}
+				seg = SVGSegment{Command: 'C', Args: append([]float64{cx, cy}, args...)}
+			default:
+				seg = SVGSegment{Command: 'C', Args: args}
+			}
+			curX, curY = args[len(args)-2], args[len(args)-1]
+		default:
+			return nil, fmt.Errorf("svg path: unsupported command %q", cmd)
+		}
+
+		if seg.Command == 'Q' || seg.Command == 'C' {
+			lastCtrlX = seg.Args[len(seg.Args)-4]
+			lastCtrlY = seg.Args[len(seg.Args)-3]
+		}
+		lastCmd = seg.Command
+		res = append(res, seg)
+	}
+	if len(res) > 0 && res[0].Command != 'M' {
+		return nil, errors.New("svg path: path must begin with a moveto")
+	}
+	return res, nil
+}
+
+// Bounds computes the bounding box of every point in
+// the path, including control points.
+func (s SVGPath) Bounds() (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, seg := range s {
+		for i := 0; i < len(seg.Args); i += 2 {
+			minX = math.Min(minX, seg.Args[i])
+			maxX = math.Max(maxX, seg.Args[i])
+			minY = math.Min(minY, seg.Args[i+1])
+			maxY = math.Max(maxY, seg.Args[i+1])
+		}
+	}
+	return
+}
+
+// An SVGStyle is a Style which fills SVG paths.
+type SVGStyle struct {
+	Paths []SVGPath
+
+	// ViewBox is the region of SVG coordinates which
+	// contains the mustache, stored as min x, min y,
+	// width, and height.
+	//
+	// The center of the view box is placed at the
+	// center of a match, and the width of the view box
+	// is scaled to the width of the mustache.
+	ViewBox [4]float64
+}
+
+// LoadSVGStyle reads an SVG file and creates a style
+// from the paths inside of it.
+func LoadSVGStyle(path string) (*SVGStyle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSVGStyle(data)
+}
+
+// ParseSVGStyle creates a style from the paths in an
+// SVG document.
+//
+// The transform attributes of paths and their ancestors
+// are applied to the paths.
+// If the document has no viewBox attribute, the view box
+// is taken to be the bounding box of the paths.
+func ParseSVGStyle(data []byte) (*SVGStyle, error) {
+	res := &SVGStyle{}
+	var viewBox string
+	transforms := []svgTransform{svgIdentity}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parse svg: %s", err)
+		}
+		if _, ok := token.(xml.EndElement); ok {
+			transforms = transforms[:len(transforms)-1]
+			continue
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		transform := transforms[len(transforms)-1]
+		var pathData string
+		var hasPath bool
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "viewBox":
+				if start.Name.Local == "svg" && viewBox == "" {
+					viewBox = attr.Value
+				}
+			case "transform":
+				t, err := parseSVGTransform(attr.Value)
+				if err != nil {
+					return nil, err
+				}
+				transform = transform.Mul(t)
+			case "d":
+				pathData, hasPath = attr.Value, start.Name.Local == "path"
+			}
+		}
+		transforms = append(transforms, transform)
+		if hasPath {
+			path, err := ParseSVGPath(pathData)
+			if err != nil {
+				return nil, err
+			}
+			res.Paths = append(res.Paths, path.transform(transform))
+		}
+	}
+	if len(res.Paths) == 0 {
+		return nil, errors.New("parse svg: no paths found")
+	}
+
+	if viewBox != "" {
+		fields := strings.FieldsFunc(viewBox, isSVGSeparator)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("parse svg: invalid viewBox: %s", viewBox)
+		}
+		for i, field := range fields {
+			num, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse svg: invalid viewBox: %s", viewBox)
+			}
+			res.ViewBox[i] = num
+		}
+	} else {
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, path := range res.Paths {
+			x1, y1, x2, y2 := path.Bounds()
+			minX, minY = math.Min(minX, x1), math.Min(minY, y1)
+			maxX, maxY = math.Max(maxX, x2), math.Max(maxY, y2)
+		}
+		res.ViewBox = [4]float64{minX, minY, maxX - minX, maxY - minY}
+	}
+	if res.ViewBox[2] <= 0 {
+		return nil, errors.New("parse svg: view box has no width")
+	}
+
+	return res, nil
+}
+
+// Draw fills the paths of the style.
+func (s *SVGStyle) Draw(ctx draw2d.GraphicContext, width float64) {
+	// We do not use ctx.Scale() for scaling because scaling
+	// up Bezier curves makes their vertices visible.
+	scale := width / s.ViewBox[2]
+	centerX := s.ViewBox[0] + s.ViewBox[2]/2
+	centerY := s.ViewBox[1] + s.ViewBox[3]/2
+
+	ctx.BeginPath()
+	for _, path := range s.Paths {
+		for _, seg := range path {
+			a := make([]float64, len(seg.Args))
+			for i, x := range seg.Args {
+				if i%2 == 0 {
+					a[i] = (x - centerX) * scale
+				} else {
+					a[i] = (x - centerY) * scale
+				}
+			}
+			switch seg.Command {
+			case 'M':
+				ctx.MoveTo(a[0], a[1])
+			case 'L':
+				ctx.LineTo(a[0], a[1])
+			case 'Q':
+				ctx.QuadCurveTo(a[0], a[1], a[2], a[3])
+			case 'C':
+				ctx.CubicCurveTo(a[0], a[1], a[2], a[3], a[4], a[5])
+			case 'Z':
+				ctx.Close()
+			}
+		}
+	}
+	ctx.Fill()
+}
+
+// An svgTransform is an affine transformation matrix,
+// stored as in the SVG matrix(a b c d e f) function.
+type svgTransform [6]float64
+
+var svgIdentity = svgTransform{1, 0, 0, 1, 0, 0}
+
+// parseSVGTransform parses a transform attribute.
+func parseSVGTransform(attr string) (svgTransform, error) {
+	res := svgIdentity
+	rest := strings.TrimSpace(attr)
+	for rest != "" {
+		open := strings.IndexByte(rest, '(')
+		end := strings.IndexByte(rest, ')')
+		if open < 0 || end < open {
+			return res, fmt.Errorf("svg transform: invalid transform: %s", attr)
+		}
+		name := strings.TrimSpace(rest[:open])
+		var args []float64
+		for _, field := range strings.FieldsFunc(rest[open+1:end], isSVGSeparator) {
+			num, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return res, fmt.Errorf("svg transform: invalid number: %s", field)
+			}
+			args = append(args, num)
+		}
+		t, err := svgTransformFunc(name, args)
+		if err != nil {
+			return res, err
+		}
+		res = res.Mul(t)
+		rest = strings.TrimLeftFunc(rest[end+1:], isSVGSeparator)
+	}
+	return res, nil
+}
+
+func svgTransformFunc(name string, args []float64) (svgTransform, error) {
+	argCount := func(counts ...int) bool {
+		for _, c := range counts {
+			if len(args) == c {
+				return true
+			}
+		}
+		return false
+	}
+	switch name {
+	case "matrix":
+		if argCount(6) {
+			var res svgTransform
+			copy(res[:], args)
+			return res, nil
+		}
+	case "translate":
+		if argCount(1, 2) {
+			args = append(args, 0)
+			return svgTransform{1, 0, 0, 1, args[0], args[1]}, nil
+		}
+	case "scale":
+		if argCount(1, 2) {
+			args = append(args, args[0])
+			return svgTransform{args[0], 0, 0, args[1], 0, 0}, nil
+		}
+	case "rotate":
+		if argCount(1, 3) {
+			angle := args[0] * math.Pi / 180
+			cos, sin := math.Cos(angle), math.Sin(angle)
+			res := svgTransform{cos, sin, -sin, cos, 0, 0}
+			if len(args) == 3 {
+				res = svgTransform{1, 0, 0, 1, args[1], args[2]}.Mul(res).Mul(
+					svgTransform{1, 0, 0, 1, -args[1], -args[2]})
+			}
+			return res, nil
+		}
+	case "skewX":
+		if argCount(1) {
+			return svgTransform{1, 0, math.Tan(args[0] * math.Pi / 180), 1, 0, 0}, nil
+		}
+	case "skewY":
+		if argCount(1) {
+			return svgTransform{1, math.Tan(args[0] * math.Pi / 180), 0, 1, 0, 0}, nil
+		}
+	default:
+		return svgIdentity, fmt.Errorf("svg transform: unsupported function %q", name)
+	}
+	return svgIdentity, fmt.Errorf("svg transform: wrong number of arguments to %s", name)
+}
+
+// Mul computes the transform which applies t2 and then t.
+func (t svgTransform) Mul(t2 svgTransform) svgTransform {
+	return svgTransform{
+		t[0]*t2[0] + t[2]*t2[1],
+		t[1]*t2[0] + t[3]*t2[1],
+		t[0]*t2[2] + t[2]*t2[3],
+		t[1]*t2[2] + t[3]*t2[3],
+		t[0]*t2[4] + t[2]*t2[5] + t[4],
+		t[1]*t2[4] + t[3]*t2[5] + t[5],
+	}
+}
+
+// Apply transforms a point.
+func (t svgTransform) Apply(x, y float64) (float64, float64) {
+	return t[0]*x + t[2]*y + t[4], t[1]*x + t[3]*y + t[5]
+}
+
+// transform applies an affine transform to every point
+// in a path.
+// Since Bezier curves are affine invariant, this is the
+// same as transforming the rendered path.
+func (s SVGPath) transform(t svgTransform) SVGPath {
+	if t == svgIdentity {
+		return s
+	}
+	res := make(SVGPath, len(s))
+	for i, seg := range s {
+		args := make([]float64, len(seg.Args))
+		for j := 0; j < len(args); j += 2 {
+			args[j], args[j+1] = t.Apply(seg.Args[j], seg.Args[j+1])
+		}
+		res[i] = SVGSegment{Command: seg.Command, Args: args}
+	}
+	return res
+}
+
+func isSVGSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+type svgPathParser struct {
+	data string
+	idx  int
+}
+
+func (s *svgPathParser) done() bool {
+	return s.idx >= len(s.data)
+}
+
+func (s *svgPathParser) skipSeparators() {
+	for !s.done() {
+		switch s.data[s.idx] {
+		case ' ', '\t', '\n', '\r', ',':
+			s.idx++
+		default:
+			return
+		}
+	}
+}
+
+func (s *svgPathParser) numbers(count int) ([]float64, error) {
+	res := make([]float64, count)
+	for i := range res {
+		s.skipSeparators()
+		num, err := s.number()
+		if err != nil {
+			return nil, err
+		}
+		res[i] = num
+	}
+	return res, nil
+}
+
+func (s *svgPathParser) number() (float64, error) {
+	start := s.idx
+	if !s.done() && (s.data[s.idx] == '-' || s.data[s.idx] == '+') {
+		s.idx++
+	}
+	var seenDot, seenExp bool
+	for !s.done() {
+		c := s.data[s.idx]
+		if c >= '0' && c <= '9' {
+			s.idx++
+		} else if c == '.' && !seenDot && !seenExp {
+			seenDot = true
+			s.idx++
+		} else if (c == 'e' || c == 'E') && !seenExp && s.idx > start {
+			seenExp = true
+			s.idx++
+			if !s.done() && (s.data[s.idx] == '-' || s.data[s.idx] == '+') {
+				s.idx++
+			}
+		} else {
+			break
+		}
+	}
+	if start == s.idx {
+		return 0, fmt.Errorf("svg path: expected number at offset %d", start)
+	}
+	num, err := strconv.ParseFloat(s.data[start:s.idx], 64)
+	if err != nil {
+		return 0, fmt.Errorf("svg path: invalid number at offset %d", start)
+	}
+	return num, nil
+}
+
+func isSVGCommand(c byte) bool {
+	return strings.IndexByte("MmLlHhVvCcSsQqTtZzAa", c) >= 0
+}
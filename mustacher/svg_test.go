@@ -0,0 +1,237 @@
+package mustacher
+
+import (
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+func TestParseSVGPath(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Data     string
+		Expected SVGPath
+	}{
+		{
+			Name: "Absolute",
+			Data: "M1,2 L3,4 Z",
+			Expected: SVGPath{
+				{'M', []float64{1, 2}},
+				{'L', []float64{3, 4}},
+				{'Z', nil},
+			},
+		},
+		{
+			Name: "Relative",
+			Data: "m1 2 l3 4 l-1-1z",
+			Expected: SVGPath{
+				{'M', []float64{1, 2}},
+				{'L', []float64{4, 6}},
+				{'L', []float64{3, 5}},
+				{'Z', nil},
+			},
+		},
+		{
+			Name: "ImplicitLineto",
+			Data: "M0,0 1,1 m1,1 2,0",
+			Expected: SVGPath{
+				{'M', []float64{0, 0}},
+				{'L', []float64{1, 1}},
+				{'M', []float64{2, 2}},
+				{'L', []float64{4, 2}},
+			},
+		},
+		{
+			Name: "ImplicitCurves",
+			Data: "M0,0 c1,1 2,2 3,3 1,0 2,0 3,0",
+			Expected: SVGPath{
+				{'M', []float64{0, 0}},
+				{'C', []float64{1, 1, 2, 2, 3, 3}},
+				{'C', []float64{4, 3, 5, 3, 6, 3}},
+			},
+		},
+		{
+			Name: "HorizontalVertical",
+			Data: "M1,1 H5 v2 h-1 V0",
+			Expected: SVGPath{
+				{'M', []float64{1, 1}},
+				{'L', []float64{5, 1}},
+				{'L', []float64{5, 3}},
+				{'L', []float64{4, 3}},
+				{'L', []float64{4, 0}},
+			},
+		},
+		{
+			Name: "SmoothCubic",
+			Data: "M0,0 C0,1 2,1 2,0 S4,-1 4,0 s2,1 2,0",
+			Expected: SVGPath{
+				{'M', []float64{0, 0}},
+				{'C', []float64{0, 1, 2, 1, 2, 0}},
+				{'C', []float64{2, -1, 4, -1, 4, 0}},
+				{'C', []float64{4, 1, 6, 1, 6, 0}},
+			},
+		},
+		{
+			Name: "SmoothCubicAfterLine",
+			Data: "M0,0 L1,0 S2,1 3,0",
+			Expected: SVGPath{
+				{'M', []float64{0, 0}},
+				{'L', []float64{1, 0}},
+				{'C', []float64{1, 0, 2, 1, 3, 0}},
+			},
+		},
+		{
+			Name: "Quadratic",
+			Data: "M0,0 Q1,1 2,0 T4,0 t2,0",
+			Expected: SVGPath{
+				{'M', []float64{0, 0}},
+				{'Q', []float64{1, 1, 2, 0}},
+				{'Q', []float64{3, -1, 4, 0}},
+				{'Q', []float64{5, 1, 6, 0}},
+			},
+		},
+		{
+			Name: "Numbers",
+			Data: "M.5-.5L1e1,2.5e-1 -3.,4",
+			Expected: SVGPath{
+				{'M', []float64{0.5, -0.5}},
+				{'L', []float64{10, 0.25}},
+				{'L', []float64{-3, 4}},
+			},
+		},
+		{
+			Name: "CloseResetsPoint",
+			Data: "M1,1 l1,0 z l0,1",
+			Expected: SVGPath{
+				{'M', []float64{1, 1}},
+				{'L', []float64{2, 1}},
+				{'Z', nil},
+				{'L', []float64{1, 2}},
+			},
+		},
+	}
+	for _, test := range tests {
+		actual, err := ParseSVGPath(test.Data)
+		if err != nil {
+			t.Errorf("%s: %s", test.Name, err)
+		} else if !pathsClose(actual, test.Expected) {
+			t.Errorf("%s: expected %v but got %v", test.Name, test.Expected, actual)
+		}
+	}
+}
+
+func TestParseSVGPathErrors(t *testing.T) {
+	for _, data := range []string{
+		"L1,1",
+		"1,1",
+		"M0,0 A1,1 0 0 1 2,2",
+		"M0,0 L1",
+		"M0,0 Z 1",
+		"M0,0 Lx,1",
+	} {
+		if _, err := ParseSVGPath(data); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
+
+func TestParseSVGStyleTransforms(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Transform string
+		Expected  []float64
+	}{
+		{"Translate", "translate(10, 20)", []float64{10, 20, 11, 20}},
+		{"TranslateX", "translate(10)", []float64{10, 0, 11, 0}},
+		{"Scale", "scale(2 3)", []float64{0, 0, 2, 0}},
+		{"Rotate", "rotate(90)", []float64{0, 0, 0, 1}},
+		{"RotateAround", "rotate(180 1 0)", []float64{2, 0, 1, 0}},
+		{"Matrix", "matrix(1 0 0 1 5 6)", []float64{5, 6, 6, 6}},
+		{"SkewX", "skewX(45)", []float64{0, 0, 1, 0}},
+		{"List", "translate(1,0) scale(2)", []float64{1, 0, 3, 0}},
+	}
+	for _, test := range tests {
+		doc := `<svg viewBox="0 0 10 10"><path transform="` + test.Transform +
+			`" d="M0,0 L1,0" /></svg>`
+		style, err := ParseSVGStyle([]byte(doc))
+		if err != nil {
+			t.Errorf("%s: %s", test.Name, err)
+			continue
+		}
+		path := style.Paths[0]
+		actual := append(append([]float64{}, path[0].Args...), path[1].Args...)
+		if !floatsClose(actual, test.Expected) {
+			t.Errorf("%s: expected %v but got %v", test.Name, test.Expected, actual)
+		}
+	}
+}
+
+func TestParseSVGStyleNestedTransforms(t *testing.T) {
+	doc := `<svg>
+		<g transform="translate(100,0)">
+			<g transform="scale(2)">
+				<path transform="translate(1,1)" d="M0,0 L1,0" />
+			</g>
+			<path d="M0,0 L1,0" />
+		</g>
+		<path d="M0,0 L1,0" />
+	</svg>`
+	style, err := ParseSVGStyle([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]float64{
+		{102, 2, 104, 2},
+		{100, 0, 101, 0},
+		{0, 0, 1, 0},
+	}
+	for i, path := range style.Paths {
+		actual := append(append([]float64{}, path[0].Args...), path[1].Args...)
+		if !floatsClose(actual, expected[i]) {
+			t.Errorf("path %d: expected %v but got %v", i, expected[i], actual)
+		}
+	}
+}
+
+func TestParseSVGStyleTransformErrors(t *testing.T) {
+	for _, transform := range []string{"spin(3)", "rotate(1 2)", "translate(x)", "scale(2"} {
+		doc := `<svg><path transform="` + transform + `" d="M0,0 L1,0" /></svg>`
+		if _, err := ParseSVGStyle([]byte(doc)); err == nil {
+			t.Errorf("expected error for %q", transform)
+		}
+	}
+}
+
+func TestClassicSVG(t *testing.T) {
+	data, err := ioutil.ReadFile("../placement_maker/mustache.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != classicSVG {
+		t.Error("classicSVG does not match placement_maker/mustache.svg")
+	}
+}
+
+func pathsClose(p1, p2 SVGPath) bool {
+	if len(p1) != len(p2) {
+		return false
+	}
+	for i, seg := range p1 {
+		if seg.Command != p2[i].Command || !floatsClose(seg.Args, p2[i].Args) {
+			return false
+		}
+	}
+	return true
+}
+
+func floatsClose(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, x := range a {
+		if math.Abs(x-b[i]) > 1e-8 {
+			return false
+		}
+	}
+	return true
+}
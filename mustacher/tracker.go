@@ -0,0 +1,295 @@
+package mustacher
+
+import (
+	"context"
+	"image"
+	"math"
+	"sort"
+)
+
+// A TrackedMatch is a Match which has been associated
+// with a track through a sequence of frames.
+type TrackedMatch struct {
+	*Match
+
+	// ID identifies the track.
+	// It is the same for every frame in which the face
+	// is followed.
+	ID int
+
+	// Missed is the number of consecutive frames, up to
+	// and including this one, in which the face was not
+	// detected.
+	// When Missed is non-zero, the match is a prediction,
+	// and its Face, Confidence, and PlacerOutput are from
+	// the last frame in which the face was detected.
+	Missed int
+}
+
+// TrackedMatches is a list of matches from a Tracker.
+type TrackedMatches []*TrackedMatch
+
+// Matches returns the underlying matches, which can be
+// passed to Draw.
+func (t TrackedMatches) Matches() []*Match {
+	res := make([]*Match, len(t))
+	for i, m := range t {
+		res[i] = m.Match
+	}
+	return res
+}
+
+// A Tracker follows faces through the frames of a video,
+// giving each face a persistent ID and smoothing its
+// mustache over time to avoid jitter.
+//
+// Each track is smoothed with an alpha-beta filter, which
+// predicts the next position of a mustache from its
+// velocity.
+// When a face is not detected in a frame, its track
+// coasts on the prediction for a few frames before it is
+// dropped.
+type Tracker struct {
+	Detector *Detector
+
+	// Smoothing controls how much a track resists new
+	// detections, from 0 (no smoothing) up to (but not
+	// including) 1.
+	Smoothing float64
+
+	// MaxMissed is the number of consecutive frames in
+	// which a face may go undetected before its track is
+	// dropped.
+	// If it is 0, the default is used.
+	// If it is negative, tracks are dropped as soon as
+	// their face is missed.
+	MaxMissed int
+
+	// MaxDistance is the farthest that a detection may be
+	// from a track's predicted position and still be
+	// associated with the track, measured in mustache
+	// radii.
+	// If it is 0, the default is used.
+	MaxDistance float64
+
+	// MinHits is the number of detections a new track
+	// needs before its matches are reported.
+	MinHits int
+
+	tracks []*track
+	nextID int
+}
+
+const (
+	defaultTrackerMaxMissed   = 5
+	defaultTrackerMaxDistance = 1
+)
+
+// NewTracker creates a Tracker with reasonable defaults.
+func NewTracker(d *Detector) *Tracker {
+	return &Tracker{
+		Detector:    d,
+		Smoothing:   0.6,
+		MaxMissed:   defaultTrackerMaxMissed,
+		MaxDistance: defaultTrackerMaxDistance,
+		MinHits:     1,
+	}
+}
+
+// Track detects faces in the next frame and updates the
+// tracks.
+// Like Detector.Match, it panics if the detector's Config
+// is invalid.
+func (t *Tracker) Track(frame image.Image) TrackedMatches {
+	return t.Update(t.Detector.Match(frame))
+}
+
+// TrackStream tracks faces in a stream of frames.
+// The resulting channel produces the matches for each
+// frame, in order.
+//
+// The channel is closed when frames is closed or ctx is
+// done.
+// A consumer which stops reading early must cancel ctx,
+// or else the tracking goroutine will never exit.
+//
+// Like Track, it panics if the detector's Config is
+// invalid.
+func (t *Tracker) TrackStream(ctx context.Context,
+	frames <-chan image.Image) <-chan TrackedMatches {
+	res := make(chan TrackedMatches)
+	go func() {
+		defer close(res)
+		for {
+			var frame image.Image
+			select {
+			case f, ok := <-frames:
+				if !ok {
+					return
+				}
+				frame = f
+			case <-ctx.Done():
+				return
+			}
+			matches, err := t.Detector.MatchContext(ctx, frame)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				panic(err)
+			}
+			select {
+			case res <- t.Update(matches):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return res
+}
+
+// Update updates the tracks using the detections from
+// the next frame.
+// This is useful when detections come from somewhere
+// other than t.Detector.
+func (t *Tracker) Update(detections []*Match) TrackedMatches {
+	for _, tr := range t.tracks {
+		tr.Predict()
+	}
+
+	type pairing struct {
+		Track     *track
+		Detection int
+		Distance  float64
+	}
+	maxDistance := t.MaxDistance
+	if maxDistance == 0 {
+		maxDistance = defaultTrackerMaxDistance
+	}
+	var pairings []pairing
+	for _, tr := range t.tracks {
+		for i, d := range detections {
+			dist := math.Hypot(d.X-tr.State.X, d.Y-tr.State.Y)
+			if dist <= maxDistance*tr.State.Radius {
+				pairings = append(pairings, pairing{tr, i, dist})
+			}
+		}
+	}
+	sort.Slice(pairings, func(i, j int) bool {
+		return pairings[i].Distance < pairings[j].Distance
+	})
+
+	usedTracks := map[*track]bool{}
+	usedDetections := make([]bool, len(detections))
+	for _, p := range pairings {
+		if usedTracks[p.Track] || usedDetections[p.Detection] {
+			continue
+		}
+		usedTracks[p.Track] = true
+		usedDetections[p.Detection] = true
+		p.Track.Correct(detections[p.Detection], 1-t.Smoothing)
+	}
+
+	maxMissed := t.MaxMissed
+	if maxMissed == 0 {
+		maxMissed = defaultTrackerMaxMissed
+	}
+	var remaining []*track
+	for _, tr := range t.tracks {
+		if !usedTracks[tr] {
+			tr.Missed++
+		}
+		if tr.Missed <= maxMissed {
+			remaining = append(remaining, tr)
+		}
+	}
+	for i, d := range detections {
+		if !usedDetections[i] {
+			state := *d
+			remaining = append(remaining, &track{ID: t.nextID, State: state, Hits: 1})
+			t.nextID++
+		}
+	}
+	t.tracks = remaining
+
+	var res TrackedMatches
+	for _, tr := range t.tracks {
+		if tr.Hits < t.MinHits {
+			continue
+		}
+		state := tr.State
+		res = append(res, &TrackedMatch{Match: &state, ID: tr.ID, Missed: tr.Missed})
+	}
+	return res
+}
+
+// Reset removes all of the tracks.
+func (t *Tracker) Reset() {
+	t.tracks = nil
+}
+
+type track struct {
+	ID     int
+	State  Match
+	Hits   int
+	Missed int
+
+	VelX      float64
+	VelY      float64
+	VelRadius float64
+	VelAngle  float64
+}
+
+// Predict advances the track by one frame.
+func (t *track) Predict() {
+	t.State.X += t.VelX
+	t.State.Y += t.VelY
+	t.State.Radius = math.Max(0, t.State.Radius+t.VelRadius)
+	t.State.Angle += t.VelAngle
+	if t.Missed > 0 {
+		// Slow down while coasting, so that a lost track
+		// does not drift away.
+		t.VelX *= 0.5
+		t.VelY *= 0.5
+		t.VelRadius *= 0.5
+		t.VelAngle *= 0.5
+	}
+}
+
+// Correct moves the predicted state toward a detection.
+func (t *track) Correct(d *Match, alpha float64) {
+	beta := alpha * alpha / (2 - alpha)
+
+	residual := d.X - t.State.X
+	t.State.X += alpha * residual
+	t.VelX += beta * residual
+
+	residual = d.Y - t.State.Y
+	t.State.Y += alpha * residual
+	t.VelY += beta * residual
+
+	residual = d.Radius - t.State.Radius
+	t.State.Radius += alpha * residual
+	t.VelRadius += beta * residual
+
+	residual = math.Remainder(d.Angle-t.State.Angle, 2*math.Pi)
+	t.State.Angle += alpha * residual
+	t.VelAngle += beta * residual
+
+	// Keep the track's original color, since per-frame
+	// color estimates would make the mustache flicker.
+	if t.State.Color == nil {
+		t.State.Color = d.Color
+	}
+	t.State.Style = d.Style
+	t.State.View = d.View
+
+	// These describe the detection itself, so they are
+	// not smoothed.
+	t.State.Face = d.Face
+	t.State.Confidence = d.Confidence
+	t.State.PlacerOutput = d.PlacerOutput
+
+	t.Hits++
+	t.Missed = 0
+}
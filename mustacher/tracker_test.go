@@ -0,0 +1,141 @@
+package mustacher
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTrackerAssociation(t *testing.T) {
+	tracker := NewTracker(nil)
+	first := tracker.Update([]*Match{
+		{X: 10, Y: 10, Radius: 5},
+		{X: 100, Y: 10, Radius: 5},
+	})
+	if len(first) != 2 {
+		t.Fatalf("expected 2 matches but got %d", len(first))
+	}
+	ids := map[float64]int{}
+	for _, m := range first {
+		ids[m.X] = m.ID
+	}
+	if ids[10] == ids[100] {
+		t.Fatal("tracks share an ID")
+	}
+
+	// The detections are listed in the opposite order and
+	// have moved a little.
+	second := tracker.Update([]*Match{
+		{X: 102, Y: 11, Radius: 5},
+		{X: 12, Y: 9, Radius: 5},
+	})
+	if len(second) != 2 {
+		t.Fatalf("expected 2 matches but got %d", len(second))
+	}
+	for _, m := range second {
+		expectedID := ids[10]
+		if m.X > 50 {
+			expectedID = ids[100]
+		}
+		if m.ID != expectedID {
+			t.Errorf("match at %f: expected ID %d but got %d", m.X, expectedID, m.ID)
+		}
+		if m.Missed != 0 {
+			t.Errorf("match at %f: unexpected Missed %d", m.X, m.Missed)
+		}
+	}
+
+	// A detection far from both tracks starts a new one.
+	third := tracker.Update([]*Match{{X: 50, Y: 200, Radius: 5}})
+	for _, m := range third {
+		if m.X == 50 && m.Y == 200 && (m.ID == ids[10] || m.ID == ids[100]) {
+			t.Error("far detection joined an existing track")
+		}
+	}
+}
+
+func TestTrackerSmoothing(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.Smoothing = 0.5
+	tracker.Update([]*Match{{X: 0, Y: 0, Radius: 10}})
+	res := tracker.Update([]*Match{{X: 4, Y: 0, Radius: 10}})
+	if len(res) != 1 {
+		t.Fatalf("expected 1 match but got %d", len(res))
+	}
+	if res[0].X <= 0 || res[0].X >= 4 {
+		t.Errorf("expected smoothed X between 0 and 4 but got %f", res[0].X)
+	}
+}
+
+func TestTrackerCoasting(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.MaxMissed = 2
+
+	// Give the track a velocity of 2 pixels per frame.
+	for i := 0; i < 10; i++ {
+		tracker.Update([]*Match{{X: float64(i * 2), Y: 0, Radius: 10}})
+	}
+	last := tracker.Update(nil)
+	if len(last) != 1 {
+		t.Fatalf("expected a coasting match but got %d matches", len(last))
+	}
+	if last[0].Missed != 1 {
+		t.Errorf("expected Missed 1 but got %d", last[0].Missed)
+	}
+	if last[0].X <= 18 {
+		t.Errorf("expected the track to keep moving past 18 but got %f", last[0].X)
+	}
+
+	if res := tracker.Update(nil); len(res) != 1 || res[0].Missed != 2 {
+		t.Fatalf("expected one match with Missed 2 but got %v", res)
+	}
+	if res := tracker.Update(nil); len(res) != 0 {
+		t.Errorf("expected the track to be dropped but got %d matches", len(res))
+	}
+}
+
+func TestTrackerDropImmediately(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.MaxMissed = -1
+	tracker.Update([]*Match{{X: 0, Y: 0, Radius: 10}})
+	if res := tracker.Update(nil); len(res) != 0 {
+		t.Errorf("expected no matches but got %d", len(res))
+	}
+}
+
+func TestTrackerMinHits(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.MinHits = 2
+	if res := tracker.Update([]*Match{{X: 0, Y: 0, Radius: 10}}); len(res) != 0 {
+		t.Errorf("expected no matches before MinHits but got %d", len(res))
+	}
+	if res := tracker.Update([]*Match{{X: 1, Y: 0, Radius: 10}}); len(res) != 1 {
+		t.Errorf("expected 1 match after MinHits but got %d", len(res))
+	}
+}
+
+func TestTrackerAngleWrap(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.Update([]*Match{{X: 0, Y: 0, Radius: 10, Angle: math.Pi - 0.05}})
+	res := tracker.Update([]*Match{{X: 0, Y: 0, Radius: 10, Angle: -math.Pi + 0.05}})
+	if len(res) != 1 {
+		t.Fatalf("expected 1 match but got %d", len(res))
+	}
+	// The smoothed angle should stay near +/-pi rather
+	// than swinging through 0.
+	if diff := math.Abs(math.Remainder(res[0].Angle-math.Pi, 2*math.Pi)); diff > 0.1 {
+		t.Errorf("angle %f is %f away from pi", res[0].Angle, diff)
+	}
+}
+
+func TestTrackerZeroValue(t *testing.T) {
+	var tracker Tracker
+	first := tracker.Update([]*Match{{X: 0, Y: 0, Radius: 10}})
+	tracker.Update(nil)
+	second := tracker.Update([]*Match{{X: 1, Y: 0, Radius: 10}})
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("expected 1 match per frame but got %d and %d", len(first), len(second))
+	}
+	if first[0].ID != second[0].ID {
+		t.Errorf("expected ID %d but got %d", first[0].ID, second[0].ID)
+	}
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/unixpickle/autofunc"
 	"github.com/unixpickle/gans"
+	"github.com/unixpickle/mustachemash/imgtensor"
 	"github.com/unixpickle/num-analysis/linalg"
 	"github.com/unixpickle/sgd"
 	"github.com/unixpickle/weakai/neuralnet"
@@ -112,15 +113,7 @@ func readFaceImages() sgd.SampleSet {
 			fmt.Fprintln(os.Stderr, "Bad size for:", subPath)
 			continue
 		}
-		tensor := neuralnet.NewTensor3(FaceSize, FaceSize, 3)
-		for y := 0; y < img.Bounds().Dy(); y++ {
-			for x := 0; x < img.Bounds().Dx(); x++ {
-				r, g, b, _ := img.At(x+img.Bounds().Min.X, y+img.Bounds().Min.Y).RGBA()
-				tensor.Set(x, y, 0, float64(r)/0xffff)
-				tensor.Set(x, y, 1, float64(g)/0xffff)
-				tensor.Set(x, y, 2, float64(b)/0xffff)
-			}
-		}
+		tensor := imgtensor.FromImage(img)
 		res = append(res, neuralnet.VectorSample{
 			Input:  tensor.Data,
 			Output: linalg.Vector{},
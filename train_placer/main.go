@@ -15,6 +15,7 @@ import (
 	_ "image/png"
 
 	"github.com/unixpickle/gans"
+	"github.com/unixpickle/mustachemash/imgtensor"
 	"github.com/unixpickle/sgd"
 	"github.com/unixpickle/weakai/neuralnet"
 )
@@ -119,7 +120,7 @@ func loadSamples(imageDir, placementFile string) sgd.SampleSet {
 			fmt.Fprintln(os.Stderr, "Decode image failed:", err)
 			os.Exit(1)
 		}
-		tensor := imageTensor(img)
+		tensor := imgtensor.FromImage(img)
 		outVec := []float64{
 			placement.CenterX,
 			placement.CenterY,
@@ -145,19 +146,6 @@ func loadSamples(imageDir, placementFile string) sgd.SampleSet {
 	return samples
 }
 
-func imageTensor(img image.Image) *neuralnet.Tensor3 {
-	res := neuralnet.NewTensor3(img.Bounds().Dx(), img.Bounds().Dy(), 3)
-	for y := 0; y < img.Bounds().Dy(); y++ {
-		for x := 0; x < img.Bounds().Dx(); x++ {
-			r, g, b, _ := img.At(x+img.Bounds().Min.X, y+img.Bounds().Min.Y).RGBA()
-			res.Set(x, y, 0, float64(r)/0xffff)
-			res.Set(x, y, 1, float64(g)/0xffff)
-			res.Set(x, y, 2, float64(b)/0xffff)
-		}
-	}
-	return res
-}
-
 func flipImage(tensor *neuralnet.Tensor3) *neuralnet.Tensor3 {
 	res := neuralnet.NewTensor3(tensor.Width, tensor.Height, tensor.Depth)
 	for y := 0; y < tensor.Height; y++ {
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A FrameReader reads the frames of a video in order.
+type FrameReader interface {
+	// ReadFrame returns io.EOF after the last frame.
+	ReadFrame() (image.Image, error)
+}
+
+// A FrameWriter writes the frames of a video in order.
+type FrameWriter interface {
+	WriteFrame(img image.Image) error
+	Close() error
+}
+
+// A DirReader reads frames from the numbered PNG files in
+// a directory.
+type DirReader struct {
+	Paths []string
+}
+
+// NewDirReader lists the PNG files in a directory, sorted
+// by the last number in their names.
+func NewDirReader(dir string) (*DirReader, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range listing {
+		if !item.IsDir() && strings.ToLower(filepath.Ext(item.Name())) == ".png" {
+			names = append(names, item.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no PNG frames in %s", dir)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		n1, n2 := frameNumber(names[i]), frameNumber(names[j])
+		if n1 != n2 {
+			return n1 < n2
+		}
+		return names[i] < names[j]
+	})
+	res := &DirReader{}
+	for _, name := range names {
+		res.Paths = append(res.Paths, filepath.Join(dir, name))
+	}
+	return res, nil
+}
+
+// ReadFrame reads the next frame.
+func (d *DirReader) ReadFrame() (image.Image, error) {
+	if len(d.Paths) == 0 {
+		return nil, io.EOF
+	}
+	path := d.Paths[0]
+	d.Paths = d.Paths[1:]
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %s", path, err)
+	}
+	return img, nil
+}
+
+// A DirWriter writes frames as numbered PNG files.
+type DirWriter struct {
+	Dir   string
+	Count int
+}
+
+// NewDirWriter creates the output directory if needed.
+func NewDirWriter(dir string) (*DirWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirWriter{Dir: dir}, nil
+}
+
+// WriteFrame writes the next frame.
+func (d *DirWriter) WriteFrame(img image.Image) error {
+	path := filepath.Join(d.Dir, fmt.Sprintf("frame_%06d.png", d.Count))
+	d.Count++
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
+
+// Close does nothing, since every frame is written as
+// soon as it is received.
+func (d *DirWriter) Close() error {
+	return nil
+}
+
+// frameNumber finds the last number in a file name, or
+// returns -1 if there is none.
+func frameNumber(name string) int {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	end := strings.LastIndexFunc(name, unicode.IsDigit)
+	if end < 0 {
+		return -1
+	}
+	start := strings.LastIndexFunc(name[:end+1], func(r rune) bool {
+		return !unicode.IsDigit(r)
+	}) + 1
+	num, err := strconv.Atoi(name[start : end+1])
+	if err != nil {
+		return -1
+	}
+	return num
+}
@@ -0,0 +1,184 @@
+// Command videostache adds mustaches to the frames of an
+// uncompressed video.
+//
+// Videos may be YUV4MPEG2 (.y4m) streams or directories
+// of numbered PNG frames, so no external codecs are
+// needed.
+// Faces are tracked from frame to frame to keep the
+// mustaches steady.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unixpickle/mustachemash/mustacher"
+)
+
+// StdioPath is used in place of a file path to read a
+// .y4m stream from standard input or write one to
+// standard output.
+const StdioPath = "-"
+
+func main() {
+	var facesPath, placerPath, inPath, outPath string
+	var styleName, colorName string
+	var fps int
+	var smoothing float64
+	var maxMissed int
+	var realistic, verbose bool
+	flag.StringVar(&facesPath, "faces", "trained_data/cascade.json", "face cascade path")
+	flag.StringVar(&placerPath, "placer", "trained_data/placer", "placer network path")
+	flag.StringVar(&inPath, "in", StdioPath, "input .y4m file, PNG frame directory, or - for stdin")
+	flag.StringVar(&outPath, "out", StdioPath,
+		"output .y4m file, PNG frame directory, or - for stdout")
+	flag.IntVar(&fps, "fps", 30, "frame rate for .y4m output from PNG frames")
+	flag.StringVar(&styleName, "style", "",
+		"style name, SVG file, or image file (default "+mustacher.DefaultStyleName+")")
+	flag.StringVar(&colorName, "color", "", "mustache color as #rrggbb (default: estimated)")
+	flag.BoolVar(&realistic, "realistic", false, "draw textured, shaded mustaches")
+	flag.Float64Var(&smoothing, "smoothing", 0.6, "tracking smoothing, from 0 up to 1")
+	flag.IntVar(&maxMissed, "max-missed", 5, "frames a face may go undetected before it is dropped")
+	flag.BoolVar(&verbose, "v", false, "log progress to stderr")
+	flag.Parse()
+
+	if flag.NArg() != 0 || fps < 1 || smoothing < 0 || smoothing >= 1 || maxMissed < 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if !verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	opts := &mustacher.DrawOptions{Realistic: realistic}
+	if styleName != "" {
+		style, err := mustacher.ParseStyle(styleName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load style:", err)
+			os.Exit(1)
+		}
+		opts.Style = style
+	}
+	if colorName != "" {
+		c, err := mustacher.ParseColor(colorName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.Color = c
+	}
+
+	reader, err := openReader(inPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open input:", err)
+		os.Exit(1)
+	}
+
+	log.Println("Loading detector...")
+	detector, err := mustacher.LoadDetector(facesPath, placerPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load detector:", err)
+		os.Exit(1)
+	}
+	tracker := mustacher.NewTracker(detector)
+	tracker.Smoothing = smoothing
+	tracker.MaxMissed = maxMissed
+
+	var writer FrameWriter
+	for i := 0; true; i++ {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read frame:", err)
+			os.Exit(1)
+		}
+		matches := tracker.Track(frame)
+		log.Printf("Frame %d: tracking %d faces.", i, len(matches))
+		outFrame := mustacher.DrawWithOptions(frame, matches.Matches(), opts)
+
+		if writer == nil {
+			writer, err = createWriter(outPath, reader, frame.Bounds(), fps)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to create output:", err)
+				os.Exit(1)
+			}
+		}
+		if err := writer.WriteFrame(outFrame); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to write frame:", err)
+			os.Exit(1)
+		}
+	}
+	if writer == nil {
+		fmt.Fprintln(os.Stderr, "Input has no frames.")
+		os.Exit(1)
+	}
+	if err := writer.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write output:", err)
+		os.Exit(1)
+	}
+}
+
+func openReader(path string) (FrameReader, error) {
+	if path == StdioPath {
+		return NewY4MReader(os.Stdin)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return NewDirReader(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// The file stays open until the process exits.
+	return NewY4MReader(f)
+}
+
+// createWriter creates a writer for the output.
+// A .y4m output copies the input's header if the input is
+// also a .y4m stream.
+func createWriter(path string, reader FrameReader, bounds image.Rectangle,
+	fps int) (FrameWriter, error) {
+	if path != StdioPath && strings.ToLower(filepath.Ext(path)) != ".y4m" {
+		return NewDirWriter(path)
+	}
+	var header *Y4MHeader
+	if y4m, ok := reader.(*Y4MReader); ok {
+		header = y4m.Header
+	} else {
+		header = NewY4MHeader(bounds.Dx(), bounds.Dy(), fps)
+	}
+	if path == StdioPath {
+		return NewY4MWriter(os.Stdout, header), nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{Y4MWriter: NewY4MWriter(f, header), file: f}, nil
+}
+
+// A fileWriter is a Y4MWriter which closes its file.
+type fileWriter struct {
+	*Y4MWriter
+	file *os.File
+}
+
+func (f *fileWriter) Close() error {
+	if err := f.Y4MWriter.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
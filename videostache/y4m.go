@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const y4mMagic = "YUV4MPEG2"
+
+// A Y4MHeader stores the parameters of a YUV4MPEG2 stream.
+type Y4MHeader struct {
+	Width  int
+	Height int
+
+	// Chroma is the color space, such as "420jpeg" or
+	// "mono".
+	Chroma string
+
+	// Params stores the remaining header parameters, such
+	// as the frame rate, so that they can be written back
+	// out unchanged.
+	Params []string
+}
+
+// NewY4MHeader creates a header for a stream of frames.
+func NewY4MHeader(width, height, fps int) *Y4MHeader {
+	return &Y4MHeader{
+		Width:  width,
+		Height: height,
+		Chroma: "420jpeg",
+		Params: []string{"F" + strconv.Itoa(fps) + ":1", "Ip", "A1:1"},
+	}
+}
+
+func (y *Y4MHeader) subsampleRatio() (ratio image.YCbCrSubsampleRatio, mono bool, err error) {
+	switch y.Chroma {
+	case "420jpeg", "420paldv", "420mpeg2", "420":
+		return image.YCbCrSubsampleRatio420, false, nil
+	case "422":
+		return image.YCbCrSubsampleRatio422, false, nil
+	case "444":
+		return image.YCbCrSubsampleRatio444, false, nil
+	case "mono":
+		return 0, true, nil
+	}
+	return 0, false, errors.New("unsupported y4m color space: " + y.Chroma)
+}
+
+// A Y4MReader reads frames from a YUV4MPEG2 stream.
+//
+// Samples are interpreted as full-range (JFIF) YCbCr.
+type Y4MReader struct {
+	Header *Y4MHeader
+
+	r     *bufio.Reader
+	ratio image.YCbCrSubsampleRatio
+	mono  bool
+}
+
+// NewY4MReader reads the header of a YUV4MPEG2 stream.
+func NewY4MReader(r io.Reader) (*Y4MReader, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read y4m header: %s", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != y4mMagic {
+		return nil, errors.New("read y4m header: not a YUV4MPEG2 stream")
+	}
+	header := &Y4MHeader{Chroma: "420jpeg"}
+	for _, field := range fields[1:] {
+		switch field[0] {
+		case 'W':
+			header.Width, err = strconv.Atoi(field[1:])
+		case 'H':
+			header.Height, err = strconv.Atoi(field[1:])
+		case 'C':
+			header.Chroma = field[1:]
+		default:
+			header.Params = append(header.Params, field)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read y4m header: invalid parameter %s", field)
+		}
+	}
+	if header.Width <= 0 || header.Height <= 0 {
+		return nil, errors.New("read y4m header: missing frame size")
+	}
+	res := &Y4MReader{Header: header, r: br}
+	res.ratio, res.mono, err = header.subsampleRatio()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReadFrame reads the next frame.
+// It returns io.EOF at the end of the stream.
+func (y *Y4MReader) ReadFrame() (image.Image, error) {
+	line, err := y.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("read y4m frame: %s", err)
+	}
+	if !strings.HasPrefix(line, "FRAME") {
+		return nil, errors.New("read y4m frame: missing FRAME marker")
+	}
+
+	bounds := image.Rect(0, 0, y.Header.Width, y.Header.Height)
+	if y.mono {
+		img := image.NewGray(bounds)
+		if _, err := io.ReadFull(y.r, img.Pix); err != nil {
+			return nil, fmt.Errorf("read y4m frame: %s", err)
+		}
+		return img, nil
+	}
+	img := image.NewYCbCr(bounds, y.ratio)
+	for _, plane := range [][]byte{img.Y, img.Cb, img.Cr} {
+		if _, err := io.ReadFull(y.r, plane); err != nil {
+			return nil, fmt.Errorf("read y4m frame: %s", err)
+		}
+	}
+	return img, nil
+}
+
+// A Y4MWriter writes frames to a YUV4MPEG2 stream.
+type Y4MWriter struct {
+	Header *Y4MHeader
+
+	w           *bufio.Writer
+	wroteHeader bool
+}
+
+// NewY4MWriter creates a writer which uses the given
+// header for the stream.
+func NewY4MWriter(w io.Writer, header *Y4MHeader) *Y4MWriter {
+	return &Y4MWriter{Header: header, w: bufio.NewWriter(w)}
+}
+
+// WriteFrame writes a frame, converting it to the color
+// space of the stream.
+// The frame must be the size given in the header.
+func (y *Y4MWriter) WriteFrame(img image.Image) error {
+	bounds := img.Bounds()
+	if bounds.Dx() != y.Header.Width || bounds.Dy() != y.Header.Height {
+		return fmt.Errorf("write y4m frame: expected %dx%d frame but got %dx%d",
+			y.Header.Width, y.Header.Height, bounds.Dx(), bounds.Dy())
+	}
+	ratio, mono, err := y.Header.subsampleRatio()
+	if err != nil {
+		return err
+	}
+	if !y.wroteHeader {
+		fields := append([]string{
+			y4mMagic,
+			"W" + strconv.Itoa(y.Header.Width),
+			"H" + strconv.Itoa(y.Header.Height),
+			"C" + y.Header.Chroma,
+		}, y.Header.Params...)
+		if _, err := y.w.WriteString(strings.Join(fields, " ") + "\n"); err != nil {
+			return err
+		}
+		y.wroteHeader = true
+	}
+	if _, err := y.w.WriteString("FRAME\n"); err != nil {
+		return err
+	}
+
+	var planes [][]byte
+	if mono {
+		gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		for row := 0; row < bounds.Dy(); row++ {
+			for col := 0; col < bounds.Dx(); col++ {
+				gray.Set(col, row, img.At(col+bounds.Min.X, row+bounds.Min.Y))
+			}
+		}
+		planes = [][]byte{gray.Pix}
+	} else {
+		ycbcr := toYCbCr(img, ratio)
+		planes = [][]byte{ycbcr.Y, ycbcr.Cb, ycbcr.Cr}
+	}
+	for _, plane := range planes {
+		if _, err := y.w.Write(plane); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the stream.
+// It does not close the underlying writer.
+func (y *Y4MWriter) Close() error {
+	return y.w.Flush()
+}
+
+// toYCbCr converts an image to a tightly packed YCbCr
+// image with its origin at (0, 0).
+// Chroma samples are averaged over each subsampled block.
+func toYCbCr(img image.Image, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	bounds := img.Bounds()
+	if ycbcr, ok := img.(*image.YCbCr); ok && ycbcr.SubsampleRatio == ratio &&
+		bounds.Min == image.ZP && ycbcr.YStride == bounds.Dx() {
+		return ycbcr
+	}
+
+	res := image.NewYCbCr(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), ratio)
+	cbSums := make([]int, len(res.Cb))
+	crSums := make([]int, len(res.Cr))
+	counts := make([]int, len(res.Cb))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, _ := img.At(x+bounds.Min.X, y+bounds.Min.Y).RGBA()
+			yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			res.Y[res.YOffset(x, y)] = yy
+			cIdx := res.COffset(x, y)
+			cbSums[cIdx] += int(cb)
+			crSums[cIdx] += int(cr)
+			counts[cIdx]++
+		}
+	}
+	for i, count := range counts {
+		if count > 0 {
+			res.Cb[i] = uint8((cbSums[i] + count/2) / count)
+			res.Cr[i] = uint8((crSums[i] + count/2) / count)
+		}
+	}
+	return res
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestY4MRoundTrip(t *testing.T) {
+	tests := []struct {
+		Chroma string
+		Ratio  image.YCbCrSubsampleRatio
+		Mono   bool
+	}{
+		{"420jpeg", image.YCbCrSubsampleRatio420, false},
+		{"444", image.YCbCrSubsampleRatio444, false},
+		{"mono", 0, true},
+	}
+	for _, test := range tests {
+		// Odd sizes make sure partial chroma blocks are kept.
+		header := &Y4MHeader{
+			Width:  5,
+			Height: 3,
+			Chroma: test.Chroma,
+			Params: []string{"F30:1", "Ip", "A1:1"},
+		}
+		var frames []image.Image
+		for i := 0; i < 2; i++ {
+			if test.Mono {
+				frames = append(frames, testGrayFrame(header, i))
+			} else {
+				frames = append(frames, testYCbCrFrame(header, test.Ratio, i))
+			}
+		}
+
+		var buf bytes.Buffer
+		w := NewY4MWriter(&buf, header)
+		for _, frame := range frames {
+			if err := w.WriteFrame(frame); err != nil {
+				t.Fatalf("%s: %s", test.Chroma, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %s", test.Chroma, err)
+		}
+
+		r, err := NewY4MReader(&buf)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Chroma, err)
+		}
+		if !reflect.DeepEqual(r.Header, header) {
+			t.Errorf("%s: expected header %v but got %v", test.Chroma, header, r.Header)
+		}
+		for i, expected := range frames {
+			actual, err := r.ReadFrame()
+			if err != nil {
+				t.Fatalf("%s: frame %d: %s", test.Chroma, i, err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s: frame %d: expected %v but got %v", test.Chroma, i, expected, actual)
+			}
+		}
+		if _, err := r.ReadFrame(); err != io.EOF {
+			t.Errorf("%s: expected EOF but got %v", test.Chroma, err)
+		}
+	}
+}
+
+func TestY4MConvertRGBA(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 10, 14, 12))
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 10), G: uint8(y * 20), B: 100, A: 0xff})
+		}
+	}
+	header := &Y4MHeader{Width: 4, Height: 2, Chroma: "444"}
+	var buf bytes.Buffer
+	w := NewY4MWriter(&buf, header)
+	if err := w.WriteFrame(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewY4MReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := r.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			r1, g1, b1, _ := img.At(x+10, y+10).RGBA()
+			r2, g2, b2, _ := frame.At(x, y).RGBA()
+			for _, diff := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8),
+				int(b1>>8) - int(b2>>8)} {
+				if diff < -2 || diff > 2 {
+					t.Errorf("pixel %d,%d: expected %v but got %v", x, y, img.At(x+10, y+10),
+						frame.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestY4MWrongFrameSize(t *testing.T) {
+	w := NewY4MWriter(&bytes.Buffer{}, NewY4MHeader(4, 4, 30))
+	if err := w.WriteFrame(image.NewGray(image.Rect(0, 0, 4, 3))); err == nil {
+		t.Error("expected error for mismatched frame size")
+	}
+}
+
+func testGrayFrame(h *Y4MHeader, seed int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, h.Width, h.Height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*7 + seed*31)
+	}
+	return img
+}
+
+func testYCbCrFrame(h *Y4MHeader, ratio image.YCbCrSubsampleRatio, seed int) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, h.Width, h.Height), ratio)
+	for i, plane := range [][]byte{img.Y, img.Cb, img.Cr} {
+		for j := range plane {
+			plane[j] = uint8(j*13 + i*50 + seed*31)
+		}
+	}
+	return img
+}